@@ -0,0 +1,109 @@
+/*
+   aggregation,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import "fmt"
+
+// Metric selects one of the values kept in history
+type Metric int
+
+const (
+	MetricCharge Metric = iota
+	MetricVoltage
+	MetricTemperature
+	numberOfMetrics
+)
+
+// AggFn reduces the samples of a window to the value stored in the next one
+// (e.g. the minute samples to one hour sample). It is never called with an
+// empty table.
+type AggFn func(table []Sample) float64
+
+// Mean returns the average value of table (the default aggregation)
+func Mean(table []Sample) float64 {
+	return avgSamples(table)
+}
+
+// Min returns the lowest value of table
+func Min(table []Sample) float64 {
+	return statsSamples(table).Min
+}
+
+// Max returns the highest value of table
+func Max(table []Sample) float64 {
+	return statsSamples(table).Max
+}
+
+// Last returns the most recent value of table
+func Last(table []Sample) float64 {
+	if len(table) == 0 {
+		return 0
+	}
+	return table[len(table)-1].Value
+}
+
+// SetAggregation sets how the samples of metric are rolled up into window:
+// for LastHour, how each minute is reduced to one sample, and for LastDays,
+// how each hour is. A nil fn restores Mean. The LastMinute window keeps the
+// raw readings, so it returns ErrOutOfRange for it.
+func (piSugar *PiSugar) SetAggregation(metric Metric, window HistoryWindow, fn AggFn) error {
+	if metric < 0 || metric >= numberOfMetrics {
+		return fmt.Errorf("%w: metric %d", ErrOutOfRange, metric)
+	}
+	if window != LastHour && window != LastDays {
+		return fmt.Errorf("%w: window %d", ErrOutOfRange, window)
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.aggregations[metric][window] = fn
+	return nil
+}
+
+// aggregate reduces table with the aggregation set for metric and window
+func (piSugar *PiSugar) aggregate(metric Metric, window HistoryWindow, table []Sample) float64 {
+	if len(table) == 0 {
+		return 0
+	}
+	if fn := piSugar.aggregations[metric][window]; fn != nil {
+		return fn(table)
+	}
+	return Mean(table)
+}
+
+// metricNames are the text forms of the metrics
+var metricNames = map[Metric]string{
+	MetricCharge:      "Charge",
+	MetricVoltage:     "Voltage",
+	MetricTemperature: "Temperature",
+}
+
+// String returns the name of the metric
+func (metric Metric) String() string {
+	return enumString(metric, metricNames)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (metric Metric) MarshalText() ([]byte, error) {
+	return enumMarshal(metric, metricNames)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (metric *Metric) UnmarshalText(text []byte) error {
+	return enumUnmarshal(text, metricNames, metric)
+}
@@ -0,0 +1,119 @@
+/*
+   alarm,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"fmt"
+	"time"
+)
+
+// Weekdays is a set of days of the week, bit n for time.Weekday n
+type Weekdays uint8
+
+// EveryDay repeats an alarm on all days of the week
+const EveryDay Weekdays = 0x7f
+
+// Days returns the set of the given days
+func Days(days ...time.Weekday) (weekdays Weekdays) {
+	for _, day := range days {
+		weekdays |= 1 << day
+	}
+	return weekdays
+}
+
+// Has tells whether day is in the set
+func (weekdays Weekdays) Has(day time.Weekday) bool {
+	return weekdays&(1<<day) != 0
+}
+
+// The PiSugar 3 alarm registers are a control register, then seconds,
+// minutes and hours, the repeat days and, for a one-off alarm, day, month
+// and year, all in BCD and UTC like the RTC, unconfirmed (see Capabilities).
+// On the PiSugar 2, those of the SD3078 are in the same order, followed by
+// their enable bits, as in its datasheet.
+const (
+	alarmReg             = 0x40
+	alarmEnableMask      = 0x80 // in alarmReg
+	sd3078AlarmReg       = 0x07
+	sd3078AlarmEnableReg = 0x0e
+	sd3078AlarmOnce      = 0x77 // year, month, day, hours, minutes, seconds
+	sd3078AlarmRepeat    = 0x0f // weekday, hours, minutes, seconds
+	sd3078AlarmInterrupt = 0x12 // INTAE and INTS0 in sd3078Ctr2Reg
+)
+
+// SetWakeAlarm programs the board to power the Pi on at t, to the second,
+// or at the time of day of t on each of repeatDays when it isn't empty.
+// It returns ErrUnsupported on boards without RTC alarm.
+func (piSugar *PiSugar) SetWakeAlarm(t time.Time, repeatDays Weekdays) error {
+	t = t.UTC()
+	if t.Year() < 2000 || t.Year() > 2099 {
+		return fmt.Errorf("%w: alarm year %d", ErrOutOfRange, t.Year())
+	}
+	repeatDays &= EveryDay
+	data := []byte{
+		toBCD(t.Second()),
+		toBCD(t.Minute()),
+		toBCD(t.Hour()),
+		byte(repeatDays),
+		toBCD(t.Day()),
+		toBCD(int(t.Month())),
+		toBCD(t.Year() - 2000),
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().RTCAlarm {
+		return ErrUnsupported
+	}
+	if piSugar.model.isPiSugar3() {
+		return piSugar.writeRegister(alarmReg, append([]byte{alarmEnableMask}, data...)...)
+	}
+	var enable byte = sd3078AlarmOnce
+	if repeatDays != 0 {
+		enable = sd3078AlarmRepeat
+	}
+	return piSugar.withAddress(sd3078I2CAddress, func() error {
+		return piSugar.sd3078Unlocked(func() error {
+			if err := piSugar.writeRegister(sd3078AlarmReg, append(data, enable)...); err != nil {
+				return err
+			}
+			return piSugar.setRegisterBits(sd3078Ctr2Reg, sd3078AlarmInterrupt, true)
+		})
+	})
+}
+
+// ClearWakeAlarm disables the wake-up alarm. It returns ErrUnsupported on
+// boards without RTC alarm.
+func (piSugar *PiSugar) ClearWakeAlarm() error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().RTCAlarm {
+		return ErrUnsupported
+	}
+	if piSugar.model.isPiSugar3() {
+		return piSugar.writeRegister(alarmReg, 0)
+	}
+	return piSugar.withAddress(sd3078I2CAddress, func() error {
+		return piSugar.sd3078Unlocked(func() error {
+			if err := piSugar.writeRegister(sd3078AlarmEnableReg, 0); err != nil {
+				return err
+			}
+			return piSugar.setRegisterBits(sd3078Ctr2Reg, sd3078AlarmInterrupt, false)
+		})
+	})
+}
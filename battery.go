@@ -0,0 +1,205 @@
+/*
+   battery,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CurvePoint maps a battery voltage to a charge percentage
+type CurvePoint struct {
+	Voltage float64
+	Percent float64
+}
+
+// defaultBatteryCurve is a typical discharge curve for a single cell LiPo
+var defaultBatteryCurve = []CurvePoint{
+	{3.10, 0},
+	{3.49, 3.2},
+	{3.52, 6.5},
+	{3.66, 10},
+	{3.79, 25.5},
+	{3.86, 40},
+	{3.92, 65},
+	{4.00, 80},
+	{4.05, 95},
+	{4.16, 100},
+}
+
+var ErrNoVoltage = errors.New("no voltage sample to derive charge from")
+
+// SetBatteryCurve sets the voltage to percentage curve used to derive the
+// charge on boards without a charge register. Points don't need to be
+// sorted; an empty curve restores the default LiPo curve.
+func (piSugar *PiSugar) SetBatteryCurve(points []CurvePoint) {
+	curve := append([]CurvePoint{}, points...)
+	sort.Slice(curve, func(i, j int) bool {
+		return curve[i].Voltage < curve[j].Voltage
+	})
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.batteryCurve = curve
+}
+
+// curvePercent interpolates the charge percentage for voltage on a curve
+// sorted by voltage, clamping outside of the curve
+func curvePercent(curve []CurvePoint, voltage float64) float64 {
+	if len(curve) == 0 {
+		curve = defaultBatteryCurve
+	}
+	if voltage <= curve[0].Voltage {
+		return curve[0].Percent
+	}
+	for i := 1; i < len(curve); i++ {
+		if voltage <= curve[i].Voltage {
+			low, high := curve[i-1], curve[i]
+			return low.Percent + (voltage-low.Voltage)/(high.Voltage-low.Voltage)*(high.Percent-low.Percent)
+		}
+	}
+	return curve[len(curve)-1].Percent
+}
+
+// readCharge returns the battery charge, from the charge register when the
+// model has a fuel gauge (with its fraction on models with ChargeFraction),
+// else from the last voltage sample and the battery curve
+func (piSugar *PiSugar) readCharge(buf []byte) (float64, error) {
+	capabilities := piSugar.model.Capabilities()
+	if !capabilities.FuelGauge {
+		n := len(piSugar.lastMinuteVoltage)
+		if n == 0 {
+			return 0, ErrNoVoltage
+		}
+		return curvePercent(piSugar.batteryCurve, piSugar.lastMinuteVoltage[n-1].Value), nil
+	}
+	if capabilities.ChargeFraction {
+		if err := piSugar.readRefreshRegister(batteryChargeReg, buf, 2); err != nil {
+			return 0, err
+		}
+		return float64(buf[0]) + float64(buf[1])/256, nil
+	}
+	if err := piSugar.readRefreshRegister(batteryChargeReg, buf, 1); err != nil {
+		return 0, err
+	}
+	return float64(buf[0]), nil
+}
+
+// trackThroughput accumulates the charge gained and lost since the previous
+// refresh, from the averaged charge so sample noise doesn't add up
+func (piSugar *PiSugar) trackThroughput(charge float64) {
+	if piSugar.throughputStarted {
+		delta := charge - piSugar.previousCharge
+		if delta > 0 {
+			piSugar.chargeIn += delta
+		} else {
+			piSugar.chargeOut -= delta
+		}
+	}
+	piSugar.previousCharge, piSugar.throughputStarted = charge, true
+}
+
+// ChargeThroughput returns the cumulated charge (in percent of the battery)
+// that went in and out of the battery since the process started
+func (piSugar *PiSugar) ChargeThroughput() (inPercent, outPercent float64) {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.chargeIn, piSugar.chargeOut
+}
+
+// EstimatedCycles returns the number of full discharge cycles since the
+// process started, i.e. the cumulated discharge divided by 100%
+func (piSugar *PiSugar) EstimatedCycles() float64 {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.chargeOut / 100
+}
+
+// outlierConfirmations is the number of consecutive outliers taken as a
+// real change of charge rather than noise
+const outlierConfirmations = 3
+
+// SetOutlierRejection drops charge samples deviating more than threshold
+// percent from the current average, unless outlierConfirmations of them
+// come in a row
+func (piSugar *PiSugar) SetOutlierRejection(enabled bool, threshold int) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.outlierRejection = enabled
+	piSugar.outlierThreshold = max(threshold, 0)
+	piSugar.outliers = 0
+}
+
+// isChargeOutlier tells whether charge should be dropped as an outlier
+func (piSugar *PiSugar) isChargeOutlier(charge float64) bool {
+	if !piSugar.outlierRejection || len(piSugar.lastMinuteCharge) == 0 {
+		return false
+	}
+	if math.Abs(charge-avgSamples(piSugar.lastMinuteCharge)) <= float64(piSugar.outlierThreshold) {
+		piSugar.outliers = 0
+		return false
+	}
+	piSugar.outliers++
+	if piSugar.outliers >= outlierConfirmations {
+		piSugar.outliers = 0
+		return false
+	}
+	Debug("Charge outlier %.1f%% dropped", charge)
+	return true
+}
+
+// Plausible battery voltage range for a single LiPo cell, readings outside
+// of it are glitches
+const (
+	DefaultMinVoltage = 2.0
+	DefaultMaxVoltage = 4.5
+)
+
+// SetVoltageRange sets the plausible battery voltage range: readings outside
+// of it are dropped before reaching the history, and counted in
+// VoltageRejections()
+func (piSugar *PiSugar) SetVoltageRange(minVoltage, maxVoltage float64) error {
+	if minVoltage >= maxVoltage {
+		return fmt.Errorf("%w: voltage range %.3fV-%.3fV", ErrOutOfRange, minVoltage, maxVoltage)
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.minVoltage, piSugar.maxVoltage = minVoltage, maxVoltage
+	return nil
+}
+
+// VoltageRejections returns the number of voltage readings dropped for
+// being out of the plausible range
+func (piSugar *PiSugar) VoltageRejections() int {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.voltageRejections
+}
+
+// isVoltagePlausible tells whether voltage is in the plausible range, and
+// counts it as rejected if not
+func (piSugar *PiSugar) isVoltagePlausible(voltage float64) bool {
+	if voltage >= piSugar.minVoltage && voltage <= piSugar.maxVoltage {
+		return true
+	}
+	piSugar.voltageRejections++
+	Debug("Implausible voltage %.3fV dropped", voltage)
+	return false
+}
@@ -0,0 +1,143 @@
+/*
+   bus,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/peergum/go-rpio/v5"
+)
+
+// I2CBus is an I2C bus as seen by PiSugar, addressing a device for each
+// transfer. It lets InitWithBus run the package on another I2C driver, or
+// on a fake board in tests (see package fake). Failures should wrap
+// ErrNACK when the device doesn't answer.
+type I2CBus interface {
+	ReadRegister(address, reg uint8, buf []byte) error
+	WriteRegister(address, reg uint8, data ...byte) error
+	Close() error
+}
+
+// InitWithBus sets up the package on bus instead of the Pi I2C controller.
+// It probes the board as model, detecting it when ModelUnknown. As Init,
+// it only returns the previous result until End() is called.
+func InitWithBus(bus I2CBus, model Model) error {
+	initMutex.Lock()
+	defer initMutex.Unlock()
+	if initDone {
+		return initErr
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.i2c = &busDevice{bus: bus, address: piSugarI2CAddress}
+	piSugar.bus = noBus
+	var err error
+	if model == ModelUnknown {
+		model, err = piSugar.probeModel()
+	} else {
+		err = piSugar.probe(model)
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrNoDevice, err)
+		piSugar.i2c = nil
+	} else {
+		piSugar.model = model
+	}
+	initDone, initErr = true, err
+	initialized = err == nil
+	return err
+}
+
+// busDevice adapts an I2CBus to the go-rpio device methods used by
+// PiSugar, turning errors into reason codes
+type busDevice struct {
+	bus     I2CBus
+	address uint32
+}
+
+func (device *busDevice) I2cReadRegister(reg uint32, buf []byte, n uint32) int {
+	if int(n) > len(buf) {
+		return i2cReasonData
+	}
+	return busCode(device.bus.ReadRegister(uint8(device.address), uint8(reg), buf[:n]))
+}
+
+func (device *busDevice) I2cWrite(data ...byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	return busCode(device.bus.WriteRegister(uint8(device.address), data[0], data[1:]...))
+}
+
+func (device *busDevice) I2cSetSlaveAddress(address uint32) {
+	device.address = address
+}
+
+func (device *busDevice) I2cEnd() {
+	if err := device.bus.Close(); err != nil {
+		warn("Can't close I2C bus %v", err)
+	}
+}
+
+// busCode returns the go-rpio reason code matching err
+func busCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrNACK):
+		return i2cReasonNACK
+	case errors.Is(err, ErrClockStretchTimeout):
+		return i2cReasonClockStretchTimeout
+	}
+	Debug("I2C bus transfer failed %v", err)
+	return i2cReasonData
+}
+
+// rpioBus is an rpio.I2cDevice as an I2CBus
+type rpioBus struct {
+	device *rpio.I2cDevice
+}
+
+// NewRPIOBus returns device as an I2CBus, e.g. to wrap the Pi I2C
+// controller with logging before giving it to InitWithBus
+func NewRPIOBus(device *rpio.I2cDevice) I2CBus {
+	return &rpioBus{device: device}
+}
+
+func (bus *rpioBus) ReadRegister(address, reg uint8, buf []byte) error {
+	bus.device.I2cSetSlaveAddress(uint32(address))
+	if code := bus.device.I2cReadRegister(uint32(reg), buf, uint32(len(buf))); code != 0 {
+		return reasonError(code)
+	}
+	return nil
+}
+
+func (bus *rpioBus) WriteRegister(address, reg uint8, data ...byte) error {
+	bus.device.I2cSetSlaveAddress(uint32(address))
+	if code := bus.device.I2cWrite(append([]byte{reg}, data...)...); code != 0 {
+		return reasonError(code)
+	}
+	return nil
+}
+
+func (bus *rpioBus) Close() error {
+	bus.device.I2cEnd()
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+   bus_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	pi_sugar "github.com/peergum/pi-sugar"
+	"github.com/peergum/pi-sugar/fake"
+)
+
+// newBus returns a fake bus with a PiSugar 3 at 80% and 3.9V, and ends the
+// session when the test is over
+func newBus(t *testing.T) *fake.Bus {
+	bus := fake.New()
+	bus.Set(0x57, 0x22, 0x0f, 0x3c)
+	bus.Set(0x57, 0x2a, 80)
+	t.Cleanup(pi_sugar.End)
+	return bus
+}
+
+func TestInitWithBusModel(t *testing.T) {
+	tests := []struct {
+		name string
+		id   byte
+		want pi_sugar.Model
+	}{
+		{"PiSugar 3", 0, pi_sugar.ModelPiSugar3},
+		// the model ID register is unconfirmed, so it isn't read
+		{"PiSugar 3 Pro", 4, pi_sugar.ModelPiSugar3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bus := newBus(t)
+			bus.Set(0x57, 0x00, test.id)
+			if err := pi_sugar.InitWithBus(bus, pi_sugar.ModelUnknown); err != nil {
+				t.Fatal(err)
+			}
+			piSugar, _ := pi_sugar.NewPiSugar()
+			if got := piSugar.Model(); got != test.want {
+				t.Errorf("Model() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInitWithBusNoDevice(t *testing.T) {
+	t.Cleanup(pi_sugar.End)
+	if err := pi_sugar.InitWithBus(fake.New(), pi_sugar.ModelUnknown); err == nil {
+		t.Error("InitWithBus on an empty bus succeeded")
+	}
+}
+
+func TestInitWithBusRefresh(t *testing.T) {
+	bus := newBus(t)
+	if err := pi_sugar.InitWithBus(bus, pi_sugar.ModelPiSugar3); err != nil {
+		t.Fatal(err)
+	}
+	piSugar, _ := pi_sugar.NewPiSugarWithHistory(pi_sugar.DefaultHistoryConfig())
+	piSugar.Refresh()
+	if got := piSugar.Charge(); got != 80 {
+		t.Errorf("Charge() = %d, want 80", got)
+	}
+	pi_sugar.End()
+	if !bus.Closed() {
+		t.Error("End() didn't close the bus")
+	}
+}
+
+func TestInitWithBusWriteEnable(t *testing.T) {
+	bus := newBus(t)
+	if err := pi_sugar.InitWithBus(bus, pi_sugar.ModelPiSugar3); err != nil {
+		t.Fatal(err)
+	}
+	piSugar, _ := pi_sugar.NewPiSugar()
+	if err := piSugar.WriteRegister(0x21, []byte{90}); err != nil {
+		t.Fatal(err)
+	}
+	want := []fake.Write{
+		{Address: 0x57, Reg: 0x0b, Data: []byte{0x29}},
+		{Address: 0x57, Reg: 0x21, Data: []byte{90}},
+		{Address: 0x57, Reg: 0x0b, Data: []byte{0}},
+	}
+	if got := bus.Writes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Writes() = %v, want %v", got, want)
+	}
+}
+
+func TestInitWithBusTwice(t *testing.T) {
+	first, second := newBus(t), newBus(t)
+	if err := pi_sugar.InitWithBus(first, pi_sugar.ModelPiSugar3); err != nil {
+		t.Fatal(err)
+	}
+	if err := pi_sugar.InitWithBus(second, pi_sugar.ModelPiSugar3); err != nil {
+		t.Fatal(err)
+	}
+	piSugar, _ := pi_sugar.NewPiSugar()
+	if err := piSugar.WriteRegister(0x21, []byte{90}); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Writes()) == 0 || len(second.Writes()) != 0 {
+		t.Errorf("second InitWithBus replaced the first bus")
+	}
+	if err := pi_sugar.InitSimulated(pi_sugar.Scenario{}); err != nil {
+		t.Fatal(err)
+	}
+	if first.Closed() {
+		t.Errorf("InitSimulated closed the bus in use")
+	}
+	pi_sugar.End()
+	if !first.Closed() {
+		t.Error("End() didn't close the bus")
+	}
+}
+
+func TestInitWithBusUnconfirmedRegisters(t *testing.T) {
+	bus := newBus(t)
+	if err := pi_sugar.InitWithBus(bus, pi_sugar.ModelPiSugar3Pro); err != nil {
+		t.Fatal(err)
+	}
+	piSugar, _ := pi_sugar.NewPiSugar()
+	writes := map[string]func() error{
+		"SetChargeLimit":         func() error { return piSugar.SetChargeLimit(90) },
+		"SetLED":                 func() error { return piSugar.SetLED(true) },
+		"SetButtonAction":        func() error { return piSugar.SetButtonAction(pi_sugar.LongPress, pi_sugar.BehaviorNone) },
+		"SetAutoShutdownPercent": func() error { return piSugar.SetAutoShutdownPercent(5) },
+		"ClearFaults":            piSugar.ClearFaults,
+		"RequestPowerOff":        func() error { return piSugar.RequestPowerOff(0) },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, pi_sugar.ErrUnsupported) {
+			t.Errorf("%s error = %v, want ErrUnsupported", name, err)
+		}
+	}
+	piSugar.Refresh()
+	if got := bus.Writes(); len(got) != 0 {
+		t.Errorf("unconfirmed registers written: %v", got)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+   button,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import "fmt"
+
+// ButtonAction is a gesture on the PiSugar power button, as recorded by
+// the firmware in the tap register
+type ButtonAction int
+
+const (
+	SingleTap ButtonAction = iota + 1
+	DoubleTap
+	LongPress
+)
+
+// tap register, unconfirmed (see Capabilities)
+const (
+	tapReg  = 0x08
+	tapMask = 0x03 // last button action, cleared by writing 0
+)
+
+// readButton reports the last button action, if any, and clears it so the
+// same press isn't reported again on the next refresh
+func (piSugar *PiSugar) readButton(buf []byte) {
+	if !piSugar.model.Capabilities().Button {
+		return
+	}
+	if err := piSugar.readRegister(tapReg, buf, 1); err != nil {
+		return
+	}
+	action := ButtonAction(buf[0] & tapMask)
+	if action == 0 {
+		return
+	}
+	if err := piSugar.writeRegister(tapReg, buf[0]&^tapMask); err != nil {
+		Debug("Can't clear button action %v", err)
+	}
+	Debug("Button action %v", action)
+	piSugar.emitEvent(Event{Kind: ButtonPressed, Action: action})
+	for _, fn := range piSugar.buttonHandlers[action] {
+		go fn()
+	}
+}
+
+// OnButton calls fn, in its own goroutine, each time Refresh sees action on
+// the power button. Actions are also reported on Events() as ButtonPressed.
+func (piSugar *PiSugar) OnButton(action ButtonAction, fn func()) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if piSugar.buttonHandlers == nil {
+		piSugar.buttonHandlers = make(map[ButtonAction][]func())
+	}
+	piSugar.buttonHandlers[action] = append(piSugar.buttonHandlers[action], fn)
+}
+
+// ButtonBehavior is what the firmware does on its own for a button action
+type ButtonBehavior int
+
+const (
+	BehaviorNone     ButtonBehavior = iota // nothing, only recorded in the tap register
+	BehaviorShutdown                       // cut the power to the Pi
+	BehaviorReboot                         // power cycle the Pi
+	BehaviorCustom                         // left to the software reading the button events
+)
+
+// buttonConfigReg is the first of the behavior registers, one per action
+// in ButtonAction order, unconfirmed (see Capabilities)
+const buttonConfigReg = 0x18
+
+// SetButtonAction sets the firmware behavior for a button action, e.g.
+// BehaviorShutdown on LongPress for a hard power cut, or BehaviorNone on
+// DoubleTap so the application handles it from the events. It returns
+// ErrUnsupported on models without Button.
+func (piSugar *PiSugar) SetButtonAction(action ButtonAction, behavior ButtonBehavior) error {
+	if action < SingleTap || action > LongPress {
+		return fmt.Errorf("%w: button action %d", ErrOutOfRange, action)
+	}
+	if behavior < BehaviorNone || behavior > BehaviorCustom {
+		return fmt.Errorf("%w: button behavior %d", ErrOutOfRange, behavior)
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().Button {
+		return ErrUnsupported
+	}
+	return piSugar.writeRegister(buttonConfigReg+byte(action-SingleTap), byte(behavior))
+}
+
+// buttonActionNames are the text forms of the button actions
+var buttonActionNames = map[ButtonAction]string{
+	SingleTap: "SingleTap",
+	DoubleTap: "DoubleTap",
+	LongPress: "LongPress",
+}
+
+// String returns the name of the button action
+func (action ButtonAction) String() string {
+	return enumString(action, buttonActionNames)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (action ButtonAction) MarshalText() ([]byte, error) {
+	return enumMarshal(action, buttonActionNames)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (action *ButtonAction) UnmarshalText(text []byte) error {
+	return enumUnmarshal(text, buttonActionNames, action)
+}
+
+// buttonBehaviorNames are the text forms of the button behaviors
+var buttonBehaviorNames = map[ButtonBehavior]string{
+	BehaviorNone:     "None",
+	BehaviorShutdown: "Shutdown",
+	BehaviorReboot:   "Reboot",
+	BehaviorCustom:   "Custom",
+}
+
+// String returns the name of the button behavior
+func (behavior ButtonBehavior) String() string {
+	return enumString(behavior, buttonBehaviorNames)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (behavior ButtonBehavior) MarshalText() ([]byte, error) {
+	return enumMarshal(behavior, buttonBehaviorNames)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (behavior *ButtonBehavior) UnmarshalText(text []byte) error {
+	return enumUnmarshal(text, buttonBehaviorNames, behavior)
+}
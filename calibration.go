@@ -0,0 +1,123 @@
+/*
+   calibration,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	calibrationInterval   = time.Minute
+	calibrationLowVoltage = 3.3 // stop the discharge there
+	calibrationLowPercent = 5   // or there, according to the fuel gauge
+	calibrationPoints     = 20  // points in the derived curve
+)
+
+var ErrExternalPower = errors.New("external power present, unplug it to calibrate")
+
+// calibrationSample is one measure of the discharge
+type calibrationSample struct {
+	time    time.Time
+	voltage float64
+	charge  int
+}
+
+// RunDischargeCalibration records the battery voltage while it discharges,
+// once a minute, until it reaches a low level, and returns a curve for
+// SetBatteryCurve. The charge comes from the fuel gauge when the model has
+// one, else from the time left until the end of the discharge (which
+// assumes a steady load). It fails with ErrExternalPower if power is
+// plugged in at any time, and returns ctx.Err() if ctx is done before the
+// end.
+func (piSugar *PiSugar) RunDischargeCalibration(ctx context.Context) ([]CurvePoint, error) {
+	var samples []calibrationSample
+	for {
+		sample, power, err := piSugar.readCalibrationSample()
+		if err != nil {
+			return nil, err
+		}
+		if power {
+			return nil, ErrExternalPower
+		}
+		samples = append(samples, sample)
+		Debug("Calibration: %.3fV, %d%%", sample.voltage, sample.charge)
+		if sample.voltage <= calibrationLowVoltage || sample.charge <= calibrationLowPercent {
+			break
+		}
+		select {
+		case <-time.After(calibrationInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return piSugar.calibrationCurve(samples), nil
+}
+
+// readCalibrationSample reads the voltage, charge and power of the board
+func (piSugar *PiSugar) readCalibrationSample() (sample calibrationSample, power bool, err error) {
+	var buf = make([]byte, 2)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	registers := piSugar.model.registers()
+	sample.time = time.Now()
+	sample.charge = 100 // unknown without a fuel gauge
+	if err = piSugar.readRegister(registers.power, buf, 1); err != nil {
+		return sample, false, err
+	}
+	power = buf[0]&registers.powerMask != 0
+	if err = piSugar.readRegister(registers.voltage, buf, 2); err != nil {
+		return sample, power, err
+	}
+	sample.voltage = registers.volts(buf)
+	if piSugar.model.Capabilities().FuelGauge {
+		if err = piSugar.readRegister(batteryChargeReg, buf, 1); err != nil {
+			return sample, power, err
+		}
+		sample.charge = int(buf[0])
+	}
+	return sample, power, nil
+}
+
+// calibrationCurve derives at most calibrationPoints curve points from the
+// discharge samples
+func (piSugar *PiSugar) calibrationCurve(samples []calibrationSample) []CurvePoint {
+	gauge := piSugar.Capabilities().FuelGauge
+	first, last := samples[0], samples[len(samples)-1]
+	duration := last.time.Sub(first.time)
+	step := max(len(samples)/calibrationPoints, 1)
+	var curve []CurvePoint
+	for i := 0; i < len(samples); i += step {
+		sample := samples[i]
+		percent := float64(sample.charge)
+		if !gauge {
+			percent = 0
+			if duration > 0 {
+				percent = float64(last.time.Sub(sample.time)) / float64(duration) * 100
+			}
+		}
+		curve = append(curve, CurvePoint{Voltage: sample.voltage, Percent: percent})
+	}
+	sort.Slice(curve, func(i, j int) bool {
+		return curve[i].Voltage < curve[j].Voltage
+	})
+	return curve
+}
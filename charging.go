@@ -0,0 +1,140 @@
+/*
+   charging,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import "fmt"
+
+// ChargeState tells what the battery is doing
+type ChargeState int
+
+const (
+	Discharging ChargeState = iota // no external power, running on battery
+	Charging                       // external power, battery charging
+	Powered                        // external power, battery not charging (e.g. disabled)
+	Full                           // external power, charge complete
+)
+
+// chargeStateNames are the text forms of the charge states
+var chargeStateNames = map[ChargeState]string{
+	Discharging: "Discharging",
+	Charging:    "Charging",
+	Powered:     "Powered",
+	Full:        "Full",
+}
+
+// String returns the name of the charge state
+func (state ChargeState) String() string {
+	return enumString(state, chargeStateNames)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (state ChargeState) MarshalText() ([]byte, error) {
+	return enumMarshal(state, chargeStateNames)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (state *ChargeState) UnmarshalText(text []byte) error {
+	return enumUnmarshal(text, chargeStateNames, state)
+}
+
+// chargeState derives the ChargeState from the power register bits
+func chargeState(power, charging, full bool) ChargeState {
+	switch {
+	case !power:
+		return Discharging
+	case charging:
+		return Charging
+	case full:
+		return Full
+	}
+	return Powered
+}
+
+// ChargeState returns the state of the battery at the last Refresh(),
+// telling a charge complete from charging stopped while powered
+func (piSugar *PiSugar) ChargeState() ChargeState {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return chargeState(piSugar.power, piSugar.charging, piSugar.full)
+}
+
+// ChargeControl registers, unconfirmed (see Capabilities)
+const (
+	chargeControlReg = 0x20
+	chargeEnableMask = 0x80 // in chargeControlReg, charging allowed
+	chargeLimitReg   = 0x21 // charge in percent at which charging stops
+)
+
+// SetChargingEnabled allows or stops the charging of the battery, e.g. to
+// keep it below 80% on a device always on mains. The Pi stays powered
+// either way. It returns ErrUnsupported on boards without ChargeControl.
+func (piSugar *PiSugar) SetChargingEnabled(enabled bool) error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().ChargeControl {
+		return ErrUnsupported
+	}
+	return piSugar.setRegisterBits(chargeControlReg, chargeEnableMask, enabled)
+}
+
+// ChargingEnabled tells whether the battery is allowed to charge. It
+// returns ErrUnsupported on boards without ChargeControl.
+func (piSugar *PiSugar) ChargingEnabled() (bool, error) {
+	var buf = make([]byte, 1)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().ChargeControl {
+		return false, ErrUnsupported
+	}
+	if err := piSugar.readRegister(chargeControlReg, buf, 1); err != nil {
+		return false, err
+	}
+	return buf[0]&chargeEnableMask != 0, nil
+}
+
+// SetChargeLimit makes the firmware stop charging the battery at percent
+// (100 charges it fully), e.g. 80 to prolong its life on a device always
+// on mains. It returns ErrUnsupported on boards without ChargeControl.
+func (piSugar *PiSugar) SetChargeLimit(percent int) error {
+	if percent < 1 || percent > 100 {
+		return fmt.Errorf("%w: charge limit %d%%", ErrOutOfRange, percent)
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().ChargeControl {
+		return ErrUnsupported
+	}
+	return piSugar.writeRegister(chargeLimitReg, byte(percent))
+}
+
+// ChargeLimit returns the charge in percent at which the firmware stops
+// charging the battery. It returns ErrUnsupported on boards without
+// ChargeControl.
+func (piSugar *PiSugar) ChargeLimit() (int, error) {
+	var buf = make([]byte, 1)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().ChargeControl {
+		return 0, ErrUnsupported
+	}
+	if err := piSugar.readRegister(chargeLimitReg, buf, 1); err != nil {
+		return 0, err
+	}
+	return int(buf[0]), nil
+}
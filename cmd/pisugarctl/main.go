@@ -0,0 +1,291 @@
+/*
+   pisugarctl,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Pisugarctl reads and configures a PiSugar from the command line.
+//
+// Usage:
+//
+//	pisugarctl [flags] status
+//	pisugarctl [flags] watch
+//	pisugarctl [flags] rtc get
+//	pisugarctl [flags] rtc set [RFC3339 time, the system time by default]
+//	pisugarctl [flags] alarm set HH:MM[:SS] [mon,tue,...]
+//	pisugarctl [flags] alarm clear
+//	pisugarctl [flags] poweroff [delay, 30s by default]
+//	pisugarctl [flags] history export
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/peergum/go-rpio/v5"
+	pi_sugar "github.com/peergum/pi-sugar"
+)
+
+// defaultPowerOffDelay leaves the OS time to halt before power is cut
+const defaultPowerOffDelay = 30 * time.Second
+
+var (
+	bus      = flag.Int("bus", 1, "I2C bus number, other than 1 only with -i2c-dev")
+	devI2C   = flag.Bool("i2c-dev", false, "use the kernel i2c-dev driver instead of mapping the I2C controller")
+	jsonOut  = flag.Bool("json", false, "print status as JSON")
+	interval = flag.Duration("interval", pi_sugar.DefaultInterval, "time between readings of watch")
+	state    = flag.String("state", "", "state file written by EnablePersistence or SaveState, for history export")
+	format   = flag.String("format", "csv", "history export format, csv or json")
+	model    pi_sugar.Model // -model, set in main
+)
+
+// weekdays are the day names accepted by alarm set
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] status|watch|rtc get|rtc set [time]|alarm set HH:MM [days]|alarm clear|poweroff [delay]|history export\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.TextVar(&model, "model", pi_sugar.ModelUnknown, "board model, needed for a PiSugar2Plus which can't be detected")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command given by args
+func run(ctx context.Context, args []string) error {
+	command, args := args[0], args[1:]
+	if command == "history" {
+		if len(args) != 1 || args[0] != "export" {
+			return errors.New("usage: history export")
+		}
+		return exportHistory()
+	}
+	piSugar, err := open()
+	if err != nil {
+		return err
+	}
+	defer pi_sugar.End()
+	switch command {
+	case "status":
+		return status(piSugar)
+	case "watch":
+		return watch(ctx, piSugar)
+	case "rtc":
+		return rtc(piSugar, args)
+	case "alarm":
+		return alarm(piSugar, args)
+	case "poweroff":
+		return powerOff(piSugar, args)
+	}
+	return fmt.Errorf("unknown command %q", command)
+}
+
+// open brings up the I2C bus selected by the flags and finds the board
+func open() (*pi_sugar.PiSugar, error) {
+	backend := pi_sugar.BackendRPIO
+	if *devI2C {
+		backend = pi_sugar.BackendDevI2C
+	} else if *bus != 1 {
+		return nil, fmt.Errorf("bus %d needs -i2c-dev", *bus)
+	}
+	if err := pi_sugar.InitBusWithBackend(rpio.I2cNum(*bus), model, backend); err != nil {
+		return nil, err
+	}
+	return pi_sugar.NewPiSugar()
+}
+
+// status prints the current readings
+func status(piSugar *pi_sugar.PiSugar) error {
+	piSugar.Refresh()
+	if _, err := piSugar.LastRefresh(); err != nil {
+		return err
+	}
+	return printStatus(piSugar.Snapshot())
+}
+
+// watch prints the readings at each refresh until ctx is done
+func watch(ctx context.Context, piSugar *pi_sugar.PiSugar) error {
+	updates, unsubscribe := piSugar.Subscribe()
+	defer unsubscribe()
+	piSugar.StartMonitoring(ctx, *interval)
+	for {
+		select {
+		case status := <-updates:
+			if err := printStatus(status); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// printStatus prints status as text or JSON
+func printStatus(status pi_sugar.Status) error {
+	if *jsonOut {
+		return json.NewEncoder(os.Stdout).Encode(status)
+	}
+	_, err := fmt.Println(status.StatusLine())
+	return err
+}
+
+// rtc reads or sets the RTC
+func rtc(piSugar *pi_sugar.PiSugar, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "get":
+		t, err := piSugar.ReadRTC()
+		if err != nil {
+			return err
+		}
+		fmt.Println(t.Local().Format(time.RFC3339))
+		return nil
+	case len(args) == 1 && args[0] == "set":
+		return piSugar.SyncRTCFromSystem()
+	case len(args) == 2 && args[0] == "set":
+		t, err := time.Parse(time.RFC3339, args[1])
+		if err != nil {
+			return err
+		}
+		return piSugar.WriteRTC(t)
+	}
+	return errors.New("usage: rtc get|set [time]")
+}
+
+// alarm sets or clears the wake-up alarm
+func alarm(piSugar *pi_sugar.PiSugar, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "clear":
+		return piSugar.ClearWakeAlarm()
+	case (len(args) == 2 || len(args) == 3) && args[0] == "set":
+		t, err := nextTime(args[1], time.Now())
+		if err != nil {
+			return err
+		}
+		var days pi_sugar.Weekdays
+		if len(args) == 3 {
+			if days, err = parseDays(args[2]); err != nil {
+				return err
+			}
+		}
+		if err := piSugar.SetWakeAlarm(t, days); err != nil {
+			return err
+		}
+		fmt.Printf("Wake-up alarm set for %s\n", t.Format(time.RFC3339))
+		return nil
+	}
+	return errors.New("usage: alarm set HH:MM[:SS] [days]|clear")
+}
+
+// nextTime returns the first time after now at the time of day text
+func nextTime(text string, now time.Time) (time.Time, error) {
+	var clock time.Time
+	var err error
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if clock, err = time.Parse(layout, text); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time of day %q", text)
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseDays parses a comma separated list of days, e.g. mon,tue
+func parseDays(text string) (pi_sugar.Weekdays, error) {
+	var days []time.Weekday
+	for _, name := range strings.Split(text, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		day, ok := weekdays[name[:min(len(name), 3)]]
+		if !ok {
+			return 0, fmt.Errorf("invalid day %q", name)
+		}
+		days = append(days, day)
+	}
+	return pi_sugar.Days(days...), nil
+}
+
+// powerOff cuts power to the Pi after a delay
+func powerOff(piSugar *pi_sugar.PiSugar, args []string) error {
+	delay := defaultPowerOffDelay
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		if delay, err = time.ParseDuration(args[0]); err != nil {
+			return err
+		}
+	default:
+		return errors.New("usage: poweroff [delay]")
+	}
+	if err := piSugar.PowerOff(delay); err != nil {
+		return err
+	}
+	fmt.Printf("Power off in %v\n", delay)
+	return nil
+}
+
+// exportHistory writes the history saved in the state file to stdout
+func exportHistory() error {
+	if *state == "" {
+		return errors.New("history export needs -state")
+	}
+	var exportFormat pi_sugar.ExportFormat
+	if err := exportFormat.UnmarshalText([]byte(strings.ToUpper(*format))); err != nil {
+		return err
+	}
+	file, err := os.Open(*state)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	piSugar, err := pi_sugar.NewPiSugar()
+	if err != nil {
+		return err
+	}
+	if err := piSugar.LoadState(file); err != nil {
+		return err
+	}
+	return piSugar.ExportHistory(os.Stdout, exportFormat)
+}
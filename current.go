@@ -0,0 +1,44 @@
+/*
+   current,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+// chargingCurrentReg holds the charging current in mA, as a signed big-endian
+// 16-bit value (negative while discharging), unconfirmed (see Capabilities)
+const chargingCurrentReg = 0x26
+
+// ChargingCurrent reads the charging current in mA from the board. It returns
+// ErrUnsupported on models without CurrentSense.
+func (piSugar *PiSugar) ChargingCurrent() (float64, error) {
+	var buf = make([]byte, 2)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().CurrentSense {
+		return 0, ErrUnsupported
+	}
+	if err := piSugar.readRegister(chargingCurrentReg, buf, 2); err != nil {
+		return 0, err
+	}
+	piSugar.current = currentFromRegister(buf)
+	return piSugar.current, nil
+}
+
+// currentFromRegister converts the 2 bytes of chargingCurrentReg to mA
+func currentFromRegister(buf []byte) float64 {
+	return float64(registerValue[int16](buf))
+}
@@ -19,20 +19,64 @@
 package pi_sugar
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
 )
 
 var (
-	debug bool
+	debug  atomic.Bool                 // read by Refresh, set at any time by SetDebug
+	logger atomic.Pointer[slog.Logger] // nil for the standard log package
 )
 
 func init() {
-	flag.BoolVar(&debug, "dsugar", false, "debug mode for pi sugar module")
+	flag.BoolFunc("dsugar", "debug mode for pi sugar module", func(value string) error {
+		enabled, err := strconv.ParseBool(value)
+		if err == nil {
+			debug.Store(enabled)
+		}
+		return err
+	})
 }
 
+// SetDebug turns the debug logs on or off, like the -dsugar flag.
+// They are off by default.
+func SetDebug(enabled bool) {
+	debug.Store(enabled)
+}
+
+// SetLogger sends the package logs to l, debug messages at slog.LevelDebug
+// and failures at slog.LevelWarn, leaving the filtering to its handler.
+// With a nil l (the default) they go to the standard log package, debug
+// messages only in debug mode.
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+// Debug logs a message, only in debug mode
 func Debug(format string, args ...interface{}) {
-	if debug {
+	logAt(slog.LevelDebug, format, args...)
+}
+
+// warn logs a failure the caller can't report as an error
+func warn(format string, args ...interface{}) {
+	logAt(slog.LevelWarn, format, args...)
+}
+
+// logAt logs a message at level, to the logger given to SetLogger if any
+func logAt(level slog.Level, format string, args ...interface{}) {
+	if l := logger.Load(); l != nil {
+		ctx := context.Background()
+		if l.Enabled(ctx, level) {
+			l.Log(ctx, level, fmt.Sprintf(format, args...))
+		}
+		return
+	}
+	if level > slog.LevelDebug || debug.Load() {
 		log.Printf("[PiSugar] "+format, args...)
 	}
 }
@@ -0,0 +1,27 @@
+/*
+   devi2c,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+// Backend is the way the I2C bus is driven
+type Backend int
+
+const (
+	BackendRPIO   Backend = iota // BSC controller registers mapped by go-rpio, needs root
+	BackendDevI2C                // kernel i2c-dev driver, /dev/i2c-N
+)
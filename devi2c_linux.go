@@ -0,0 +1,151 @@
+/*
+   devi2c_linux,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/peergum/go-rpio/v5"
+)
+
+// i2c-dev ioctls, from linux/i2c-dev.h and linux/i2c.h
+const (
+	i2cRdwr    = 0x0707 // combined transfer, with repeated starts
+	i2cMsgRead = 0x0001 // flag of a read message
+	i2cMsgMax  = 0xffff // bytes in a message, its length being a __u16
+)
+
+// i2cMsg is struct i2c_msg
+type i2cMsg struct {
+	addr  uint16
+	flags uint16
+	len   uint16
+	buf   *byte
+}
+
+// i2cRdwrData is struct i2c_rdwr_ioctl_data
+type i2cRdwrData struct {
+	msgs  *i2cMsg
+	nmsgs uint32
+}
+
+// devI2C drives the bus through /dev/i2c-N, returning go-rpio reason codes
+// so it can stand in for an rpio.I2cDevice
+type devI2C struct {
+	file    *os.File
+	address uint32
+	err     error // of the last transfer, when its reason code is i2cReasonBackend
+}
+
+// openDevI2C opens the i2c-dev device of bus dev, addressing address
+func openDevI2C(dev rpio.I2cNum, address uint32) (i2cReader, error) {
+	file, err := os.OpenFile(fmt.Sprintf("/dev/i2c-%d", dev), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &devI2C{file: file, address: address}, nil
+}
+
+// I2cReadRegister reads n bytes from register reg into buf, with a
+// repeated start
+func (device *devI2C) I2cReadRegister(reg uint32, buf []byte, n uint32) int {
+	if n == 0 || int(n) > len(buf) {
+		return i2cReasonData
+	}
+	if n > i2cMsgMax {
+		return device.tooLong(int(n))
+	}
+	regBuf := []byte{byte(reg)}
+	return device.rdwr([]i2cMsg{
+		{addr: uint16(device.address), len: 1, buf: &regBuf[0]},
+		{addr: uint16(device.address), flags: i2cMsgRead, len: uint16(n), buf: &buf[0]},
+	})
+}
+
+// I2cRead reads n bytes into buf, without selecting a register
+func (device *devI2C) I2cRead(buf []byte, n uint32) int {
+	if n == 0 || int(n) > len(buf) {
+		return i2cReasonData
+	}
+	if n > i2cMsgMax {
+		return device.tooLong(int(n))
+	}
+	return device.rdwr([]i2cMsg{
+		{addr: uint16(device.address), flags: i2cMsgRead, len: uint16(n), buf: &buf[0]},
+	})
+}
+
+// I2cWrite writes data in a single message
+func (device *devI2C) I2cWrite(data ...byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	if len(data) > i2cMsgMax {
+		return device.tooLong(len(data))
+	}
+	return device.rdwr([]i2cMsg{
+		{addr: uint16(device.address), len: uint16(len(data)), buf: &data[0]},
+	})
+}
+
+// I2cSetSlaveAddress sets the address of the following transfers
+func (device *devI2C) I2cSetSlaveAddress(address uint32) {
+	device.address = address
+}
+
+// I2cEnd closes the device
+func (device *devI2C) I2cEnd() {
+	device.file.Close()
+}
+
+// rdwr runs msgs as one combined transfer. The kernel reports a missing
+// ACK as EREMOTEIO (ENXIO for some adapters) and a clock stretch timeout as
+// ETIMEDOUT; other failures are kept for backendError.
+func (device *devI2C) rdwr(msgs []i2cMsg) int {
+	device.err = nil
+	data := i2cRdwrData{msgs: &msgs[0], nmsgs: uint32(len(msgs))}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, device.file.Fd(), i2cRdwr, uintptr(unsafe.Pointer(&data)))
+	if errno == 0 {
+		return 0
+	}
+	Debug("i2c-dev transfer failed %v", errno)
+	switch {
+	case errors.Is(errno, syscall.EREMOTEIO), errors.Is(errno, syscall.ENXIO):
+		return i2cReasonNACK
+	case errors.Is(errno, syscall.ETIMEDOUT):
+		return i2cReasonClockStretchTimeout
+	}
+	device.err = fmt.Errorf("i2c-dev transfer failed: %w", errno)
+	return i2cReasonBackend
+}
+
+// tooLong fails a transfer of n bytes, more than a message can hold
+func (device *devI2C) tooLong(n int) int {
+	device.err = fmt.Errorf("%w: %d bytes in an i2c-dev message", ErrOutOfRange, n)
+	return i2cReasonBackend
+}
+
+// backendError implements backendErrorer
+func (device *devI2C) backendError() error {
+	return device.err
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+/*
+   devi2c_other,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"fmt"
+
+	"github.com/peergum/go-rpio/v5"
+)
+
+// openDevI2C fails, i2c-dev being a Linux driver
+func openDevI2C(dev rpio.I2cNum, address uint32) (i2cReader, error) {
+	return nil, fmt.Errorf("%w: /dev/i2c-%d outside of Linux", ErrUnsupported, dev)
+}
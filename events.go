@@ -0,0 +1,222 @@
+/*
+   events,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"context"
+	"time"
+)
+
+// EventKind is the type of state transition reported by an Event
+type EventKind int
+
+const (
+	PowerLost     EventKind = iota // external power went away
+	PowerRestored                  // external power came back
+	LowBattery                     // charge dropped below lowBatteryPercent
+	BatteryFull                    // battery reported full by the firmware or charge reached fullBatteryPercent
+	ButtonPressed                  // the power button was used, see Event.Action
+	FaultRaised                    // protection faults were latched, see Event.Faults
+)
+
+// Event is a state transition detected by Refresh
+type Event struct {
+	Kind   EventKind
+	Time   time.Time
+	Action ButtonAction // set for ButtonPressed
+	Faults Faults       // new faults, set for FaultRaised
+}
+
+const (
+	eventBufferSize     = 16
+	DefaultEventLogSize = 100
+	lowBatteryPercent   = 20
+	fullBatteryPercent  = 100
+)
+
+// eventState is the part of the state watched for transitions
+type eventState struct {
+	power      bool
+	lowBattery bool
+	full       bool
+}
+
+// Events returns a channel receiving an Event for each transition seen by
+// Refresh. The channel is buffered; when the consumer falls behind the
+// oldest events are dropped so Refresh never blocks.
+func (piSugar *PiSugar) Events() <-chan Event {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if piSugar.events == nil {
+		piSugar.events = make(chan Event, eventBufferSize)
+	}
+	return piSugar.events
+}
+
+// emit queues an event of the given kind
+func (piSugar *PiSugar) emit(kind EventKind) {
+	piSugar.emitEvent(Event{Kind: kind})
+}
+
+// emitEvent timestamps and queues event, dropping the oldest one if the
+// buffer is full
+func (piSugar *PiSugar) emitEvent(event Event) {
+	event.Time = time.Now()
+	piSugar.logEvent(event)
+	if piSugar.events == nil {
+		return
+	}
+	for {
+		select {
+		case piSugar.events <- event:
+			return
+		default:
+			select {
+			case <-piSugar.events:
+			default:
+			}
+		}
+	}
+}
+
+// detectEvents compares the current state with the one from the previous
+// refresh and emits an event for each edge
+func (piSugar *PiSugar) detectEvents() {
+	state := eventState{
+		power:      piSugar.power,
+		lowBattery: piSugar.charge < lowBatteryPercent,
+		full:       piSugar.full || piSugar.charge >= fullBatteryPercent,
+	}
+	previous, seen := piSugar.eventState, piSugar.eventStateSeen
+	piSugar.eventState, piSugar.eventStateSeen = state, true
+	if !seen || previous.power != state.power {
+		piSugar.signalPowerChange()
+	}
+	if !seen {
+		return
+	}
+	if previous.power && !state.power {
+		piSugar.emit(PowerLost)
+	} else if !previous.power && state.power {
+		piSugar.emit(PowerRestored)
+	}
+	if !previous.lowBattery && state.lowBattery {
+		piSugar.emit(LowBattery)
+	}
+	if !previous.full && state.full {
+		piSugar.emit(BatteryFull)
+	}
+}
+
+// WaitForPower blocks until external power is present, or ctx is done
+func (piSugar *PiSugar) WaitForPower(ctx context.Context) error {
+	return piSugar.waitForPowerState(ctx, true)
+}
+
+// WaitForPowerLoss blocks until the board runs on battery, or ctx is done
+func (piSugar *PiSugar) WaitForPowerLoss(ctx context.Context) error {
+	return piSugar.waitForPowerState(ctx, false)
+}
+
+// waitForPowerState waits for the power state seen by Refresh to be power.
+// It relies on the edge detection of Refresh rather than reading the board.
+func (piSugar *PiSugar) waitForPowerState(ctx context.Context, power bool) error {
+	for {
+		piSugar.mutex.Lock()
+		if piSugar.eventStateSeen && piSugar.eventState.power == power {
+			piSugar.mutex.Unlock()
+			return nil
+		}
+		if piSugar.powerChanged == nil {
+			piSugar.powerChanged = make(chan struct{})
+		}
+		changed := piSugar.powerChanged
+		piSugar.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// signalPowerChange wakes up the goroutines waiting for a power change
+func (piSugar *PiSugar) signalPowerChange() {
+	if piSugar.powerChanged != nil {
+		close(piSugar.powerChanged)
+		piSugar.powerChanged = nil
+	}
+}
+
+// logEvent appends event to the event log, dropping the oldest entry when
+// the log is full
+func (piSugar *PiSugar) logEvent(event Event) {
+	if piSugar.eventLogSize <= 0 {
+		return
+	}
+	if len(piSugar.eventLog) >= piSugar.eventLogSize {
+		piSugar.eventLog = piSugar.eventLog[len(piSugar.eventLog)-piSugar.eventLogSize+1:]
+	}
+	piSugar.eventLog = append(piSugar.eventLog, event)
+}
+
+// EventLog returns the last events detected by Refresh, oldest first,
+// whether or not they were consumed from Events()
+func (piSugar *PiSugar) EventLog() []Event {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return append([]Event{}, piSugar.eventLog...)
+}
+
+// SetEventLogSize sets how many events EventLog keeps (DefaultEventLogSize
+// by default, 0 disables the log). The most recent entries are kept.
+func (piSugar *PiSugar) SetEventLogSize(size int) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.eventLogSize = max(size, 0)
+	if len(piSugar.eventLog) > piSugar.eventLogSize {
+		piSugar.eventLog = append([]Event{}, piSugar.eventLog[len(piSugar.eventLog)-piSugar.eventLogSize:]...)
+	}
+}
+
+// eventKindNames are the text forms of the event kinds
+var eventKindNames = map[EventKind]string{
+	PowerLost:     "PowerLost",
+	PowerRestored: "PowerRestored",
+	LowBattery:    "LowBattery",
+	BatteryFull:   "BatteryFull",
+	ButtonPressed: "ButtonPressed",
+	FaultRaised:   "FaultRaised",
+}
+
+// String returns the name of the event kind
+func (kind EventKind) String() string {
+	return enumString(kind, eventKindNames)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (kind EventKind) MarshalText() ([]byte, error) {
+	return enumMarshal(kind, eventKindNames)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (kind *EventKind) UnmarshalText(text []byte) error {
+	return enumUnmarshal(text, eventKindNames, kind)
+}
@@ -0,0 +1,107 @@
+/*
+   export,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ExportFormat is the file format written by ExportHistory
+type ExportFormat int
+
+const (
+	ExportCSV  ExportFormat = iota // window,metric,time,value rows, with a header
+	ExportJSON                     // an array of History, one per window
+)
+
+// ExportHistory writes the minute, hour and days history of the voltage,
+// charge and temperature to w in format, e.g. for a spreadsheet or Grafana
+func (piSugar *PiSugar) ExportHistory(w io.Writer, format ExportFormat) error {
+	piSugar.mutex.RLock()
+	var histories []History
+	for _, window := range []HistoryWindow{LastMinute, LastHour, LastDays} {
+		histories = append(histories, History{
+			Window:      window,
+			Voltage:     samples(window, piSugar.lastMinuteVoltage, piSugar.lastHourVoltage, piSugar.lastDayVoltage),
+			Charge:      samples(window, piSugar.lastMinuteCharge, piSugar.lastHourCharge, piSugar.lastDayCharge),
+			Temperature: samples(window, piSugar.lastMinuteTemperature, piSugar.lastHourTemperature, piSugar.lastDayTemperature),
+		})
+	}
+	piSugar.mutex.RUnlock()
+	switch format {
+	case ExportCSV:
+		return exportCSV(w, histories)
+	case ExportJSON:
+		return json.NewEncoder(w).Encode(histories)
+	}
+	return fmt.Errorf("%w: export format %d", ErrOutOfRange, format)
+}
+
+// exportCSV writes histories as one row per sample
+func exportCSV(w io.Writer, histories []History) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"window", "metric", "time", "value"})
+	for _, history := range histories {
+		for _, series := range []struct {
+			metric  Metric
+			samples []Sample
+		}{
+			{MetricVoltage, history.Voltage},
+			{MetricCharge, history.Charge},
+			{MetricTemperature, history.Temperature},
+		} {
+			for _, sample := range series.samples {
+				writer.Write([]string{
+					history.Window.String(),
+					series.metric.String(),
+					sample.Time.Format(time.RFC3339),
+					strconv.FormatFloat(sample.Value, 'f', -1, 64),
+				})
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// exportFormatNames are the text forms of the export formats
+var exportFormatNames = map[ExportFormat]string{
+	ExportCSV:  "CSV",
+	ExportJSON: "JSON",
+}
+
+// String returns the name of the export format
+func (format ExportFormat) String() string {
+	return enumString(format, exportFormatNames)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (format ExportFormat) MarshalText() ([]byte, error) {
+	return enumMarshal(format, exportFormatNames)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (format *ExportFormat) UnmarshalText(text []byte) error {
+	return enumUnmarshal(text, exportFormatNames, format)
+}
@@ -0,0 +1,95 @@
+/*
+   export_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// exportPiSugar returns a PiSugar with a known history
+func exportPiSugar() *PiSugar {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	piSugar, _ := newTestPiSugar(time.Second)
+	piSugar.lastMinuteVoltage = []Sample{{Time: at, Value: 3.9}, {Time: at.Add(time.Second), Value: 3.85}}
+	piSugar.lastMinuteCharge = []Sample{{Time: at, Value: 80}}
+	piSugar.lastHourTemperature = []Sample{{Time: at, Value: 25.5}}
+	piSugar.lastDayCharge = []Sample{{Time: at, Value: 79}}
+	return piSugar
+}
+
+func TestExportHistoryCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportPiSugar().ExportHistory(&buf, ExportCSV); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"window", "metric", "time", "value"},
+		{LastMinute.String(), MetricVoltage.String(), "2024-01-01T12:00:00Z", "3.9"},
+		{LastMinute.String(), MetricVoltage.String(), "2024-01-01T12:00:01Z", "3.85"},
+		{LastMinute.String(), MetricCharge.String(), "2024-01-01T12:00:00Z", "80"},
+		{LastHour.String(), MetricTemperature.String(), "2024-01-01T12:00:00Z", "25.5"},
+		{LastDays.String(), MetricCharge.String(), "2024-01-01T12:00:00Z", "79"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("CSV rows = %q, want %q", rows, want)
+	}
+}
+
+func TestExportHistoryJSON(t *testing.T) {
+	piSugar := exportPiSugar()
+	var buf bytes.Buffer
+	if err := piSugar.ExportHistory(&buf, ExportJSON); err != nil {
+		t.Fatal(err)
+	}
+	var histories []History
+	if err := json.Unmarshal(buf.Bytes(), &histories); err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 3 {
+		t.Fatalf("%d histories, want 3", len(histories))
+	}
+	for i, window := range []HistoryWindow{LastMinute, LastHour, LastDays} {
+		if histories[i].Window != window {
+			t.Errorf("history %d window = %v, want %v", i, histories[i].Window, window)
+		}
+	}
+	if got, want := histories[0].Voltage, piSugar.lastMinuteVoltage; !reflect.DeepEqual(got, want) {
+		t.Errorf("minute voltage = %v, want %v", got, want)
+	}
+	if got, want := histories[2].Charge, piSugar.lastDayCharge; !reflect.DeepEqual(got, want) {
+		t.Errorf("days charge = %v, want %v", got, want)
+	}
+}
+
+func TestExportHistoryFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportPiSugar().ExportHistory(&buf, ExportJSON+1); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("ExportHistory error = %v, want ErrOutOfRange", err)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+   fake,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package fake is an in-memory pi_sugar.I2CBus with scriptable register
+// maps, to run pi_sugar off-device, e.g. in unit tests:
+//
+//	bus := fake.New()
+//	bus.Set(0x57, 0x2a, 80) // PiSugar 3 at 80%
+//	pi_sugar.InitWithBus(bus, pi_sugar.ModelPiSugar3)
+package fake
+
+import (
+	"fmt"
+	"sync"
+
+	pi_sugar "github.com/peergum/pi-sugar"
+)
+
+// Write is a register write seen by the bus
+type Write struct {
+	Address uint8
+	Reg     uint8
+	Data    []byte
+}
+
+// location is a register of a device
+type location struct {
+	address uint8
+	reg     uint8
+}
+
+// Bus is a fake I2C bus. Only the addresses given to Set answer, the
+// others NACK. It is safe for concurrent use.
+type Bus struct {
+	mutex    sync.Mutex
+	devices  map[uint8]*[256]byte
+	scripts  map[location][][]byte
+	failures map[uint8]error
+	writes   []Write
+	closed   bool
+}
+
+var _ pi_sugar.I2CBus = (*Bus)(nil)
+
+// New returns a bus without devices
+func New() *Bus {
+	return &Bus{
+		devices:  map[uint8]*[256]byte{},
+		scripts:  map[location][][]byte{},
+		failures: map[uint8]error{},
+	}
+}
+
+// Set writes data to the registers of address starting at reg, adding the
+// device if needed. Unlike WriteRegister, it isn't recorded in Writes.
+func (bus *Bus) Set(address, reg uint8, data ...byte) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.store(address, reg, data)
+}
+
+// Get returns n bytes from the registers of address starting at reg
+func (bus *Bus) Get(address, reg uint8, n int) []byte {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	data := make([]byte, n)
+	if registers, ok := bus.devices[address]; ok {
+		for i := range data {
+			data[i] = registers[reg+uint8(i)]
+		}
+	}
+	return data
+}
+
+// Script queues reads: the following reads starting at reg return each of
+// reads in turn, then the register map again
+func (bus *Bus) Script(address, reg uint8, reads ...[]byte) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	key := location{address, reg}
+	bus.scripts[key] = append(bus.scripts[key], reads...)
+}
+
+// Fail makes the transfers to address fail with err, until called again
+// with a nil err
+func (bus *Bus) Fail(address uint8, err error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if err == nil {
+		delete(bus.failures, address)
+	} else {
+		bus.failures[address] = err
+	}
+}
+
+// Writes returns the register writes seen so far
+func (bus *Bus) Writes() []Write {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return append([]Write{}, bus.writes...)
+}
+
+// Closed tells whether Close was called
+func (bus *Bus) Closed() bool {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.closed
+}
+
+// ReadRegister implements pi_sugar.I2CBus
+func (bus *Bus) ReadRegister(address, reg uint8, buf []byte) error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	registers, err := bus.device(address)
+	if err != nil {
+		return err
+	}
+	key := location{address, reg}
+	if script := bus.scripts[key]; len(script) > 0 {
+		bus.scripts[key] = script[1:]
+		copy(buf, script[0])
+		return nil
+	}
+	for i := range buf {
+		buf[i] = registers[reg+uint8(i)]
+	}
+	return nil
+}
+
+// WriteRegister implements pi_sugar.I2CBus
+func (bus *Bus) WriteRegister(address, reg uint8, data ...byte) error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if _, err := bus.device(address); err != nil {
+		return err
+	}
+	bus.store(address, reg, data)
+	bus.writes = append(bus.writes, Write{Address: address, Reg: reg, Data: append([]byte{}, data...)})
+	return nil
+}
+
+// Close implements pi_sugar.I2CBus
+func (bus *Bus) Close() error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.closed = true
+	return nil
+}
+
+// device returns the registers of address, or the error of a transfer to it
+func (bus *Bus) device(address uint8) (*[256]byte, error) {
+	if err := bus.failures[address]; err != nil {
+		return nil, err
+	}
+	registers, ok := bus.devices[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: no device at 0x%02x", pi_sugar.ErrNACK, address)
+	}
+	return registers, nil
+}
+
+// store writes data to the registers of address starting at reg
+func (bus *Bus) store(address, reg uint8, data []byte) {
+	registers, ok := bus.devices[address]
+	if !ok {
+		registers = &[256]byte{}
+		bus.devices[address] = registers
+	}
+	for i, value := range data {
+		registers[reg+uint8(i)] = value
+	}
+}
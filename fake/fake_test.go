@@ -0,0 +1,123 @@
+/*
+   fake_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fake
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	pi_sugar "github.com/peergum/pi-sugar"
+)
+
+func TestSetGet(t *testing.T) {
+	bus := New()
+	bus.Set(0x57, 0x22, 0x0f, 0x3c)
+	if got := bus.Get(0x57, 0x22, 2); !bytes.Equal(got, []byte{0x0f, 0x3c}) {
+		t.Errorf("Get = % x, want 0f 3c", got)
+	}
+	if got := bus.Get(0x75, 0x22, 2); !bytes.Equal(got, []byte{0, 0}) {
+		t.Errorf("Get from an unknown device = % x, want 00 00", got)
+	}
+	buf := make([]byte, 2)
+	if err := bus.ReadRegister(0x57, 0x22, buf); err != nil || !bytes.Equal(buf, []byte{0x0f, 0x3c}) {
+		t.Errorf("ReadRegister = % x, %v, want 0f 3c, nil", buf, err)
+	}
+	if writes := bus.Writes(); len(writes) != 0 {
+		t.Errorf("Set recorded writes %v", writes)
+	}
+}
+
+func TestUnknownAddress(t *testing.T) {
+	bus := New()
+	if err := bus.ReadRegister(0x57, 0, make([]byte, 1)); !errors.Is(err, pi_sugar.ErrNACK) {
+		t.Errorf("ReadRegister error = %v, want ErrNACK", err)
+	}
+	if err := bus.WriteRegister(0x57, 0, 1); !errors.Is(err, pi_sugar.ErrNACK) {
+		t.Errorf("WriteRegister error = %v, want ErrNACK", err)
+	}
+}
+
+func TestScript(t *testing.T) {
+	bus := New()
+	bus.Set(0x57, 0x2a, 80)
+	bus.Script(0x57, 0x2a, []byte{10}, []byte{20})
+	buf := make([]byte, 1)
+	for _, want := range []byte{10, 20, 80, 80} {
+		if err := bus.ReadRegister(0x57, 0x2a, buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf[0] != want {
+			t.Errorf("read %d, want %d", buf[0], want)
+		}
+	}
+}
+
+func TestFail(t *testing.T) {
+	bus := New()
+	bus.Set(0x57, 0, 0)
+	bus.Fail(0x57, pi_sugar.ErrClockStretchTimeout)
+	if err := bus.ReadRegister(0x57, 0, make([]byte, 1)); !errors.Is(err, pi_sugar.ErrClockStretchTimeout) {
+		t.Errorf("ReadRegister error = %v, want ErrClockStretchTimeout", err)
+	}
+	if err := bus.WriteRegister(0x57, 0, 1); !errors.Is(err, pi_sugar.ErrClockStretchTimeout) {
+		t.Errorf("WriteRegister error = %v, want ErrClockStretchTimeout", err)
+	}
+	if writes := bus.Writes(); len(writes) != 0 {
+		t.Errorf("failed write recorded %v", writes)
+	}
+	bus.Fail(0x57, nil)
+	if err := bus.ReadRegister(0x57, 0, make([]byte, 1)); err != nil {
+		t.Errorf("ReadRegister after Fail(nil) = %v", err)
+	}
+}
+
+func TestWrites(t *testing.T) {
+	bus := New()
+	bus.Set(0x57, 0, 0)
+	data := []byte{1, 2}
+	if err := bus.WriteRegister(0x57, 0x10, data...); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 9
+	if err := bus.WriteRegister(0x57, 0xff, 3); err != nil {
+		t.Fatal(err)
+	}
+	want := []Write{{0x57, 0x10, []byte{1, 2}}, {0x57, 0xff, []byte{3}}}
+	if got := bus.Writes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Writes = %v, want %v", got, want)
+	}
+	if got := bus.Get(0x57, 0x10, 2); !bytes.Equal(got, []byte{1, 2}) {
+		t.Errorf("registers after write = % x, want 01 02", got)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	bus := New()
+	if bus.Closed() {
+		t.Error("new bus is closed")
+	}
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bus.Closed() {
+		t.Error("bus isn't closed after Close")
+	}
+}
@@ -0,0 +1,76 @@
+/*
+   faults,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+// Faults is a set of protection faults latched by the battery IC
+type Faults uint8
+
+const (
+	OverTemp Faults = 1 << iota
+	OverCurrent
+	OverVoltage
+	UnderVoltage
+)
+
+// faultReg holds the latched faults, cleared by writing 0, unconfirmed
+// (see Capabilities)
+const faultReg = 0x0c
+
+// Has tells whether all the faults of f are set
+func (faults Faults) Has(f Faults) bool {
+	return faults&f == f
+}
+
+// Faults returns the protection faults read by the last Refresh()
+func (piSugar *PiSugar) Faults() Faults {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.faults
+}
+
+// ClearFaults resets the latched faults, once their cause is gone. It
+// returns ErrUnsupported on models without Faults.
+func (piSugar *PiSugar) ClearFaults() error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().Faults {
+		return ErrUnsupported
+	}
+	if err := piSugar.writeRegister(faultReg, 0); err != nil {
+		return err
+	}
+	piSugar.faults = 0
+	return nil
+}
+
+// readFaults updates the faults and emits a FaultRaised event for new ones
+func (piSugar *PiSugar) readFaults(buf []byte) {
+	if !piSugar.model.Capabilities().Faults {
+		return
+	}
+	if err := piSugar.readRefreshRegister(faultReg, buf, 1); err != nil {
+		return
+	}
+	faults := Faults(buf[0]) & (OverTemp | OverCurrent | OverVoltage | UnderVoltage)
+	if raised := faults &^ piSugar.faults; raised != 0 {
+		Debug("Faults raised: %04b", raised)
+		piSugar.emitEvent(Event{Kind: FaultRaised, Faults: raised})
+	}
+	piSugar.faults = faults
+}
@@ -0,0 +1,36 @@
+/*
+   firmware,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import "fmt"
+
+// FirmwareVersion reads the firmware version from the board, as "major.minor"
+// (e.g. "3.13"). It returns ErrUnsupported on models without Firmware.
+func (piSugar *PiSugar) FirmwareVersion() (string, error) {
+	var buf = make([]byte, 2)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().Firmware {
+		return "", ErrUnsupported
+	}
+	if err := piSugar.readRegister(firmwareReg, buf, 2); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%d", buf[0], buf[1]), nil
+}
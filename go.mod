@@ -2,4 +2,21 @@ module github.com/peergum/pi-sugar
 
 go 1.22
 
-require github.com/peergum/go-rpio/v5 v5.0.3
+require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/peergum/go-rpio/v5 v5.0.3
+	github.com/prometheus/client_golang v1.19.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sync v0.3.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
@@ -0,0 +1,242 @@
+/*
+   history,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import "time"
+
+// HistoryWindow selects one of the history buffers kept by Refresh
+type HistoryWindow int
+
+const (
+	LastMinute HistoryWindow = iota // one sample per refresh
+	LastHour                        // one average per minute
+	LastDays                        // one average per hour, over numberOfDays
+)
+
+// HistoryConfig sets how many values each history window keeps.
+// The hour window is fed from the minute window and the day window from the
+// hour window, so disabling the hour window (size 0) disables the days one.
+// The minute window always keeps at least one sample, since the current
+// readings are averaged from it.
+//
+// The sizes can instead be derived from durations: when Resolution or
+// Retention is set, the sizes left at 0 hold a minute of refreshes, an hour
+// of minutes and Retention (numberOfDays days by default) of hours, e.g.
+// HistoryConfig{Resolution: 5 * time.Second, Retention: 30 * 24 * time.Hour}.
+type HistoryConfig struct {
+	Minute int // samples kept in the last-minute window
+	Hour   int // minute averages kept in the last-hour window
+	Days   int // hour averages kept in the days window
+
+	Resolution time.Duration // time between refreshes, as given to StartMonitoring
+	Retention  time.Duration // time covered by the days window
+}
+
+// DefaultHistoryConfig returns the default sizes: 60 seconds, 60 minutes
+// and numberOfDays days
+func DefaultHistoryConfig() HistoryConfig {
+	return HistoryConfig{
+		Minute: secondsInAMinute,
+		Hour:   minutesInAnHour,
+		Days:   hoursInADay * numberOfDays,
+	}
+}
+
+// setHistoryConfig applies config and resets all history buffers
+func (piSugar *PiSugar) setHistoryConfig(config HistoryConfig) {
+	if config.Resolution > 0 || config.Retention > 0 {
+		config = config.sized()
+	}
+	if config.Resolution > 0 {
+		piSugar.interval = config.Resolution
+	}
+	config.Minute = max(config.Minute, 1)
+	config.Hour = max(config.Hour, 0)
+	config.Days = max(config.Days, 0)
+	if config.Hour == 0 {
+		config.Days = 0
+	}
+	piSugar.historyConfig = config
+	piSugar.lastMinuteCharge = make([]Sample, 0, config.Minute)
+	piSugar.lastHourCharge = make([]Sample, 0, config.Hour)
+	piSugar.lastDayCharge = make([]Sample, 0, config.Days)
+	piSugar.lastMinuteVoltage = make([]Sample, 0, config.Minute)
+	piSugar.lastHourVoltage = make([]Sample, 0, config.Hour)
+	piSugar.lastDayVoltage = make([]Sample, 0, config.Days)
+	piSugar.lastMinuteTemperature = make([]Sample, 0, config.Minute)
+	piSugar.lastHourTemperature = make([]Sample, 0, config.Hour)
+	piSugar.lastDayTemperature = make([]Sample, 0, config.Days)
+	piSugar.counter = 0
+}
+
+// sized returns config with the sizes left at 0 derived from Resolution
+// and Retention
+func (config HistoryConfig) sized() HistoryConfig {
+	resolution := config.Resolution
+	if resolution <= 0 {
+		resolution = DefaultInterval
+	}
+	retention := config.Retention
+	if retention <= 0 {
+		retention = hoursInADay * numberOfDays * time.Hour
+	}
+	if config.Minute == 0 {
+		config.Minute = max(int(time.Minute/resolution), 1)
+	}
+	if config.Hour == 0 {
+		config.Hour = minutesInAnHour
+	}
+	if config.Days == 0 {
+		config.Days = max(int(retention/time.Hour), 1)
+	}
+	return config
+}
+
+// Sample is a value of the history and the time it was taken (for the hour
+// and days windows, the time the average was computed)
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Stats summarizes the samples held in a history window
+type Stats struct {
+	Min, Max, Avg float64
+	Count         int
+}
+
+// statsSamples returns min, max and average values of table
+func statsSamples(table []Sample) (stats Stats) {
+	if len(table) == 0 {
+		return stats
+	}
+	stats.Min, stats.Max = table[0].Value, table[0].Value
+	for _, v := range table {
+		stats.Min = min(stats.Min, v.Value)
+		stats.Max = max(stats.Max, v.Value)
+	}
+	stats.Avg = avgSamples(table)
+	stats.Count = len(table)
+	return stats
+}
+
+// VoltageStats returns min/max/average battery voltage over the given window
+func (piSugar *PiSugar) VoltageStats(window HistoryWindow) Stats {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return statsSamples(windowSamples(window, piSugar.lastMinuteVoltage, piSugar.lastHourVoltage, piSugar.lastDayVoltage))
+}
+
+// ChargeStats returns min/max/average battery charge over the given window
+func (piSugar *PiSugar) ChargeStats(window HistoryWindow) Stats {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return statsSamples(windowSamples(window, piSugar.lastMinuteCharge, piSugar.lastHourCharge, piSugar.lastDayCharge))
+}
+
+// TemperatureStats returns min/max/average temperature over the given window
+func (piSugar *PiSugar) TemperatureStats(window HistoryWindow) Stats {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return statsSamples(windowSamples(window, piSugar.lastMinuteTemperature, piSugar.lastHourTemperature, piSugar.lastDayTemperature))
+}
+
+// windowSamples returns the samples of the given window among minute, hour
+// and days
+func windowSamples(window HistoryWindow, minute, hour, days []Sample) []Sample {
+	switch window {
+	case LastMinute:
+		return minute
+	case LastHour:
+		return hour
+	case LastDays:
+		return days
+	}
+	return nil
+}
+
+// samples returns a copy of the samples of the given window among minute,
+// hour and days
+func samples(window HistoryWindow, minute, hour, days []Sample) []Sample {
+	return append([]Sample{}, windowSamples(window, minute, hour, days)...)
+}
+
+// VoltageHistory returns the battery voltage samples of the given window
+func (piSugar *PiSugar) VoltageHistory(window HistoryWindow) []Sample {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return samples(window, piSugar.lastMinuteVoltage, piSugar.lastHourVoltage, piSugar.lastDayVoltage)
+}
+
+// ChargeHistory returns the battery charge samples of the given window
+func (piSugar *PiSugar) ChargeHistory(window HistoryWindow) []Sample {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return samples(window, piSugar.lastMinuteCharge, piSugar.lastHourCharge, piSugar.lastDayCharge)
+}
+
+// TemperatureHistory returns the temperature samples of the given window
+func (piSugar *PiSugar) TemperatureHistory(window HistoryWindow) []Sample {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return samples(window, piSugar.lastMinuteTemperature, piSugar.lastHourTemperature, piSugar.lastDayTemperature)
+}
+
+// VoltageAvg returns the average battery voltage over the given window
+func (piSugar *PiSugar) VoltageAvg(window HistoryWindow) float64 {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return avgSamples(windowSamples(window, piSugar.lastMinuteVoltage, piSugar.lastHourVoltage, piSugar.lastDayVoltage))
+}
+
+// ChargeAvg returns the average battery charge over the given window
+func (piSugar *PiSugar) ChargeAvg(window HistoryWindow) float64 {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return avgSamples(windowSamples(window, piSugar.lastMinuteCharge, piSugar.lastHourCharge, piSugar.lastDayCharge))
+}
+
+// TemperatureAvg returns the average temperature over the given window
+func (piSugar *PiSugar) TemperatureAvg(window HistoryWindow) float64 {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return avgSamples(windowSamples(window, piSugar.lastMinuteTemperature, piSugar.lastHourTemperature, piSugar.lastDayTemperature))
+}
+
+// historyWindowNames are the text forms of the history windows
+var historyWindowNames = map[HistoryWindow]string{
+	LastMinute: "LastMinute",
+	LastHour:   "LastHour",
+	LastDays:   "LastDays",
+}
+
+// String returns the name of the history window
+func (window HistoryWindow) String() string {
+	return enumString(window, historyWindowNames)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (window HistoryWindow) MarshalText() ([]byte, error) {
+	return enumMarshal(window, historyWindowNames)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (window *HistoryWindow) UnmarshalText(text []byte) error {
+	return enumUnmarshal(text, historyWindowNames, window)
+}
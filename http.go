@@ -0,0 +1,84 @@
+/*
+   http,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// ServeHTTP writes the current Snapshot() as JSON, or the String() one-liner
+// with ?format=text, so a PiSugar can be mounted on any mux
+func (piSugar *PiSugar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := piSugar.Snapshot()
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, status.String()+"\n")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		Debug("Can't encode status %v", err)
+	}
+}
+
+// History is the JSON body served on /history by Handler()
+type History struct {
+	Window      HistoryWindow `json:"window"`
+	Voltage     []Sample      `json:"voltage"`
+	Charge      []Sample      `json:"charge"`
+	Temperature []Sample      `json:"temperature"`
+}
+
+// Handler returns an http.Handler serving the current readings on /status
+// (see ServeHTTP) and the history as JSON on /history, for the window given
+// by ?window= (LastMinute, LastHour or LastDays, LastHour by default)
+func (piSugar *PiSugar) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/status", piSugar)
+	mux.HandleFunc("/history", piSugar.serveHistory)
+	return mux
+}
+
+// serveHistory writes the history of the window asked for as JSON
+func (piSugar *PiSugar) serveHistory(w http.ResponseWriter, r *http.Request) {
+	window := LastHour
+	if text := r.URL.Query().Get("window"); text != "" {
+		if err := window.UnmarshalText([]byte(text)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	history := History{
+		Window:      window,
+		Voltage:     piSugar.VoltageHistory(window),
+		Charge:      piSugar.ChargeHistory(window),
+		Temperature: piSugar.TemperatureHistory(window),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		Debug("Can't encode history %v", err)
+	}
+}
+
+// ListenAndServe serves the PiSugar status on addr
+func (piSugar *PiSugar) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, piSugar)
+}
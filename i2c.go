@@ -0,0 +1,155 @@
+/*
+   i2c,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"errors"
+	"time"
+
+	"github.com/peergum/go-rpio/v5"
+)
+
+const (
+	firstI2cAddress = 0x03
+	lastI2cAddress  = 0x77
+
+	busClearPulses    = 9                    // enough for a slave to finish any byte
+	busClearHalfCycle = 5 * time.Microsecond // 100kHz
+)
+
+var ErrBusStuck = errors.New("I2C bus stuck: SDA held low")
+
+// i2cPins returns the SDA and SCL pins of a bus, as wired by go-rpio
+func i2cPins(dev rpio.I2cNum) (sda, scl rpio.Pin, ok bool) {
+	switch dev {
+	case rpio.I2c1:
+		return 2, 3, true
+	}
+	return 0, 0, false
+}
+
+// noBus is the bus of the devices given to InitWithBus or InitSimulated
+const noBus rpio.I2cNum = -1
+
+// i2cReader is a device which can read without selecting a register first,
+// as needed by I2cScan
+type i2cReader interface {
+	i2c
+	I2cRead(buf []byte, len uint32) int
+}
+
+// I2cScan probes every address from 0x03 to 0x77 on the given bus with a
+// one byte read and returns those which acknowledged, like i2cdetect.
+// The bus the PiSugar was initialized on is scanned through its backend,
+// which is then pointed back at the PiSugar. Other buses are scanned
+// through /dev/i2c-N. It returns ErrUnsupported when the backend can't scan.
+func I2cScan(dev rpio.I2cNum) ([]uint8, error) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if piSugar.i2c != nil && piSugar.bus == dev {
+		device, ok := piSugar.i2c.(i2cReader)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		defer piSugar.I2cSetSlaveAddress(piSugar.model.registers().address)
+		return scan(device), nil
+	}
+	device, err := openDevI2C(dev, firstI2cAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer device.I2cEnd()
+	return scan(device), nil
+}
+
+// scan returns the addresses acknowledging a one byte read on device
+func scan(device i2cReader) []uint8 {
+	var buf = make([]byte, 1)
+	var found []uint8
+	for address := uint32(firstI2cAddress); address <= lastI2cAddress; address++ {
+		device.I2cSetSlaveAddress(address)
+		if device.I2cRead(buf, 1) == 0 {
+			Debug("I2C device found at 0x%02x", address)
+			found = append(found, uint8(address))
+		}
+	}
+	return found
+}
+
+// I2cBusClear checks the bus is idle and, if a slave holds SDA low, clocks
+// SCL up to 9 times and sends a STOP to release it, as in the standard I2C
+// recovery. It returns ErrBusStuck if SDA is still low afterwards.
+// The pins are given back to the I2C controller in any case.
+func I2cBusClear(dev rpio.I2cNum) error {
+	sda, scl, ok := i2cPins(dev)
+	if !ok {
+		return ErrUnsupported
+	}
+	// initMutex keeps End from unmapping the GPIO memory under the pins
+	initMutex.Lock()
+	defer initMutex.Unlock()
+	if !initialized {
+		return ErrNotInitialized
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !rpioOpened {
+		return ErrUnsupported
+	}
+	defer func() {
+		sda.Mode(rpio.I2c)
+		scl.Mode(rpio.I2c)
+	}()
+
+	// released lines float high through the pull-ups
+	release := func(pin rpio.Pin) {
+		pin.Input()
+		pin.PullUp()
+	}
+	drive := func(pin rpio.Pin) {
+		pin.Output()
+		pin.Low()
+	}
+	release(sda)
+	release(scl)
+	time.Sleep(busClearHalfCycle)
+	if sda.Read() == rpio.High {
+		return nil
+	}
+
+	Debug("SDA held low, clearing bus")
+	for i := 0; i < busClearPulses && sda.Read() == rpio.Low; i++ {
+		drive(scl)
+		time.Sleep(busClearHalfCycle)
+		release(scl)
+		time.Sleep(busClearHalfCycle)
+	}
+	// STOP: SDA goes high while SCL is high
+	drive(scl)
+	drive(sda)
+	time.Sleep(busClearHalfCycle)
+	release(scl)
+	time.Sleep(busClearHalfCycle)
+	release(sda)
+	time.Sleep(busClearHalfCycle)
+	if sda.Read() == rpio.Low {
+		return ErrBusStuck
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+/*
+   led,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+// LED registers, unconfirmed (see Capabilities)
+const (
+	ledReg      = 0x0d
+	ledOnMask   = 0x80 // in ledReg
+	ledColorReg = 0x0e // red, green, blue
+)
+
+// SetLED turns the indicator LED on or off. It returns ErrUnsupported on
+// boards without LED control.
+func (piSugar *PiSugar) SetLED(on bool) error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().LED {
+		return ErrUnsupported
+	}
+	return piSugar.setRegisterBits(ledReg, ledOnMask, on)
+}
+
+// SetLEDColor sets the color of the RGB LED. It returns ErrUnsupported on
+// boards without an RGB LED.
+func (piSugar *PiSugar) SetLEDColor(r, g, b uint8) error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().RGBLED {
+		return ErrUnsupported
+	}
+	return piSugar.writeRegister(ledColorReg, r, g, b)
+}
@@ -0,0 +1,73 @@
+/*
+   lowbattery,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"log/slog"
+	"os/exec"
+)
+
+// lowBatteryReadings is the number of consecutive readings below the
+// threshold needed to call a low battery hook, so a single bad reading or
+// a load spike doesn't shut the Pi down
+const lowBatteryReadings = 5
+
+// lowBatteryHook is a function registered with OnLowBattery
+type lowBatteryHook struct {
+	threshold int
+	fn        func(charge int)
+	below     int  // consecutive readings below threshold
+	fired     bool // fn was called, until the charge goes back up
+}
+
+// OnLowBattery calls fn, in its own goroutine, when the charge stays below
+// threshold percent for lowBatteryReadings refreshes in a row while on
+// battery. It is called once per discharge, and again only after the charge
+// went back to threshold or above, or external power was plugged in. Pass
+// ShutdownOS as fn for a graceful shutdown.
+func (piSugar *PiSugar) OnLowBattery(threshold int, fn func(charge int)) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.lowBatteryHooks = append(piSugar.lowBatteryHooks, &lowBatteryHook{threshold: threshold, fn: fn})
+}
+
+// checkLowBattery calls the low battery hooks due after a refresh
+func (piSugar *PiSugar) checkLowBattery() {
+	for _, hook := range piSugar.lowBatteryHooks {
+		if piSugar.power || piSugar.charge >= hook.threshold {
+			hook.below, hook.fired = 0, false
+			continue
+		}
+		hook.below++
+		if hook.below >= lowBatteryReadings && !hook.fired {
+			hook.fired = true
+			Debug("Battery at %d%%, below %d%%", piSugar.charge, hook.threshold)
+			go hook.fn(piSugar.charge)
+		}
+	}
+}
+
+// ShutdownOS halts the system with `shutdown -h now`. Its signature lets it
+// be given to OnLowBattery.
+func ShutdownOS(charge int) {
+	logAt(slog.LevelInfo, "Battery at %d%%, shutting down", charge)
+	if err := exec.Command("shutdown", "-h", "now").Run(); err != nil {
+		warn("Can't shut down %v", err)
+	}
+}
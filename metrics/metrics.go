@@ -0,0 +1,75 @@
+/*
+   metrics,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package metrics exposes PiSugar readings to Prometheus. It lives in its own
+// package so that only users who import it depend on the Prometheus client.
+package metrics
+
+import (
+	pi_sugar "github.com/peergum/pi-sugar"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector reports the values of the last Refresh(); scraping never
+// triggers an I2C read
+type Collector struct {
+	piSugar     *pi_sugar.PiSugar
+	voltage     *prometheus.Desc
+	charge      *prometheus.Desc
+	temperature *prometheus.Desc
+	power       *prometheus.Desc
+	charging    *prometheus.Desc
+}
+
+// NewCollector returns a collector for piSugar, ready for prometheus.Register
+func NewCollector(piSugar *pi_sugar.PiSugar) *Collector {
+	return &Collector{
+		piSugar:     piSugar,
+		voltage:     prometheus.NewDesc("pisugar_voltage_volts", "Battery voltage.", nil, nil),
+		charge:      prometheus.NewDesc("pisugar_charge_percent", "Battery charge.", nil, nil),
+		temperature: prometheus.NewDesc("pisugar_temperature_celsius", "Board temperature.", nil, nil),
+		power:       prometheus.NewDesc("pisugar_power_present", "1 when external power is present.", nil, nil),
+		charging:    prometheus.NewDesc("pisugar_charging", "1 when the battery is charging.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.voltage
+	ch <- collector.charge
+	ch <- collector.temperature
+	ch <- collector.power
+	ch <- collector.charging
+}
+
+// Collect implements prometheus.Collector
+func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
+	status := collector.piSugar.Snapshot()
+	ch <- prometheus.MustNewConstMetric(collector.voltage, prometheus.GaugeValue, status.Voltage)
+	ch <- prometheus.MustNewConstMetric(collector.charge, prometheus.GaugeValue, float64(status.Charge))
+	ch <- prometheus.MustNewConstMetric(collector.temperature, prometheus.GaugeValue, float64(status.Temperature))
+	ch <- prometheus.MustNewConstMetric(collector.power, prometheus.GaugeValue, boolToFloat64(status.Power))
+	ch <- prometheus.MustNewConstMetric(collector.charging, prometheus.GaugeValue, boolToFloat64(status.Charging))
+}
+
+func boolToFloat64(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
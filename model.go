@@ -0,0 +1,256 @@
+/*
+   model,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+// Model is the PiSugar board model. The PiSugar 3 Plus uses the same
+// registers as the PiSugar 3 and is reported as such.
+type Model int
+
+const (
+	ModelUnknown Model = iota
+	ModelPiSugar2
+	ModelPiSugar3
+	ModelPiSugar3Pro
+	ModelPiSugar2Plus
+)
+
+// modelIDReg tells a PiSugar 3 (3) from a PiSugar 3 Pro (4). No datasheet
+// or pisugar-server source confirms it, so it is only read with ModelID.
+const modelIDReg = 0x00
+
+// Capabilities lists the features available on a model. Features whose
+// registers no datasheet or pisugar-server source confirms are left off,
+// so their methods return ErrUnsupported rather than touch registers that
+// may mean something else to the firmware.
+type Capabilities struct {
+	FuelGauge bool // charge register, else the charge comes from the battery curve
+	LED       bool // indicator LED on/off
+	RGBLED    bool // indicator LED color
+	Watchdog  bool // firmware watchdog
+	RTCAlarm  bool // RTC and wake-up alarm
+
+	CurrentSense   bool // charging current register
+	ChargeControl  bool // charging can be disabled or limited
+	ChargeFraction bool // fraction of percent register after the charge one
+	PowerControl   bool // output switch and delayed power off
+
+	Button       bool // tap register and firmware button behaviors
+	Faults       bool // latched protection faults
+	Firmware     bool // firmware version register
+	ModelID      bool // model register telling the PiSugar 3 from the 3 Pro
+	AutoShutdown bool // power cut below a battery percentage
+}
+
+// Capabilities returns the features available on the model. On the
+// PiSugar 3 family only the registers read by the original driver are
+// confirmed (power, temperature, voltage and charge), the others wait for
+// a source.
+func (model Model) Capabilities() Capabilities {
+	switch model {
+	case ModelPiSugar2, ModelPiSugar2Plus:
+		return Capabilities{RTCAlarm: true}
+	case ModelPiSugar3, ModelPiSugar3Pro:
+		return Capabilities{FuelGauge: true}
+	}
+	return Capabilities{}
+}
+
+// isPiSugar3 tells whether the model uses the PiSugar 3 register map
+func (model Model) isPiSugar3() bool {
+	return model == ModelPiSugar3 || model == ModelPiSugar3Pro
+}
+
+// Model returns the board model detected by Init()
+func (piSugar *PiSugar) Model() Model {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.model
+}
+
+// Capabilities returns the features of the detected board model
+func (piSugar *PiSugar) Capabilities() Capabilities {
+	return piSugar.Model().Capabilities()
+}
+
+// HardwareModel reads the model ID from the board and updates Model() with
+// it. Models without ModelID return the model found by Init().
+func (piSugar *PiSugar) HardwareModel() (Model, error) {
+	var buf = make([]byte, 1)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if piSugar.i2c == nil {
+		return ModelUnknown, ErrNotInitialized
+	}
+	if !piSugar.model.Capabilities().ModelID {
+		return piSugar.model, nil
+	}
+	if err := piSugar.readRegister(modelIDReg, buf, 1); err != nil {
+		return piSugar.model, err
+	}
+	piSugar.model = modelFromID(buf[0])
+	return piSugar.model, nil
+}
+
+// detectModel identifies the board answering at piSugarI2CAddress, which
+// can only be from the PiSugar 3 family. Without ModelID it is reported as
+// a PiSugar 3, so a 3 Pro must be given to InitModel.
+func (piSugar *PiSugar) detectModel() Model {
+	var buf = make([]byte, 1)
+	if !ModelPiSugar3.Capabilities().ModelID {
+		return ModelPiSugar3
+	}
+	if err := piSugar.readRegister(modelIDReg, buf, 1); err != nil {
+		return ModelPiSugar3
+	}
+	return modelFromID(buf[0])
+}
+
+// modelFromID returns the model of a PiSugar 3 family board from the value
+// of modelIDReg
+func modelFromID(id byte) Model {
+	if id == 4 {
+		return ModelPiSugar3Pro
+	}
+	return ModelPiSugar3
+}
+
+// probeModel looks for a PiSugar 3 at piSugarI2CAddress, then for a PiSugar 2
+// at piSugar2I2CAddress, leaving the device addressing the board found. The
+// PiSugar 2 and 2 Plus can't be told apart, so a 2 Plus must be given to
+// InitModel or InitBusWithBackend.
+func (piSugar *PiSugar) probeModel() (Model, error) {
+	if err := piSugar.probe(ModelPiSugar3); err == nil {
+		return piSugar.detectModel(), nil
+	}
+	if err := piSugar.probe(ModelPiSugar2); err != nil {
+		return ModelUnknown, err
+	}
+	return ModelPiSugar2, nil
+}
+
+// probe addresses the board as model and checks it answers
+func (piSugar *PiSugar) probe(model Model) error {
+	registers := model.registers()
+	piSugar.I2cSetSlaveAddress(registers.address)
+	var buf = make([]byte, 1)
+	return piSugar.readRegister(registers.power, buf, 1)
+}
+
+// registerMap locates the readings of a model family. Masks are 0, and
+// registers 0, for what the model doesn't report.
+type registerMap struct {
+	address      uint32
+	power        byte // external power and battery state bits
+	powerMask    byte
+	chargingMask byte
+	gpioMask     byte
+	fullMask     byte
+	criticalMask byte
+	voltage      byte // battery voltage, 2 bytes decoded by volts
+	volts        func(buf []byte) float64
+	temperature  byte
+	inputVoltage byte // 2 bytes, in mV
+}
+
+// PiSugar 2 power management chips (IP5209 on the 2, IP5312 on the 2 Plus)
+const (
+	piSugar2I2CAddress = 0x75
+	ip5209PowerReg     = 0x55
+	ip5209PowerMask    = 0x10 // USB plugged, the battery charges from it
+	ip5209VoltageReg   = 0xa2
+	ip5312PowerReg     = 0x58
+	ip5312PowerMask    = 0x20
+	ip5312VoltageReg   = 0xd0
+)
+
+var (
+	piSugar3Registers = registerMap{
+		address:      piSugarI2CAddress,
+		power:        powerReg,
+		powerMask:    powerExternalMask,
+		chargingMask: powerChargingMask,
+		voltage:      voltageReg,
+		volts:        volts,
+		temperature:  temperatureReg,
+		// powerGPIOMask, powerFullMask, powerCriticalMask and
+		// inputVoltageReg wait for a source, see Capabilities
+	}
+	piSugar2Registers = registerMap{
+		address:      piSugar2I2CAddress,
+		power:        ip5209PowerReg,
+		powerMask:    ip5209PowerMask,
+		chargingMask: ip5209PowerMask,
+		voltage:      ip5209VoltageReg,
+		volts:        ip5xxxVolts,
+	}
+	piSugar2PlusRegisters = registerMap{
+		address:      piSugar2I2CAddress,
+		power:        ip5312PowerReg,
+		powerMask:    ip5312PowerMask,
+		chargingMask: ip5312PowerMask,
+		voltage:      ip5312VoltageReg,
+		volts:        ip5xxxVolts,
+	}
+)
+
+// registers returns the register map of the model, the PiSugar 3 one (also
+// used by the 3 Plus) when it is unknown
+func (model Model) registers() registerMap {
+	switch model {
+	case ModelPiSugar2:
+		return piSugar2Registers
+	case ModelPiSugar2Plus:
+		return piSugar2PlusRegisters
+	}
+	return piSugar3Registers
+}
+
+// ip5xxxVolts converts the voltage of the PiSugar 2 chips to volts: 14 bits
+// two's complement, little-endian, in 0.26855mV steps above 2.6V
+func ip5xxxVolts(buf []byte) float64 {
+	raw := int(buf[1]&0x3f)<<8 | int(buf[0])
+	if raw&0x2000 != 0 {
+		raw -= 0x4000
+	}
+	return (2600 + float64(raw)*0.26855) / 1000
+}
+
+// modelNames are the text forms of the board models
+var modelNames = map[Model]string{
+	ModelUnknown:      "Unknown",
+	ModelPiSugar2:     "PiSugar2",
+	ModelPiSugar3:     "PiSugar3",
+	ModelPiSugar3Pro:  "PiSugar3Pro",
+	ModelPiSugar2Plus: "PiSugar2Plus",
+}
+
+// String returns the name of the board model
+func (model Model) String() string {
+	return enumString(model, modelNames)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (model Model) MarshalText() ([]byte, error) {
+	return enumMarshal(model, modelNames)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (model *Model) UnmarshalText(text []byte) error {
+	return enumUnmarshal(text, modelNames, model)
+}
@@ -0,0 +1,66 @@
+/*
+   monitor,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultInterval is the time between refreshes assumed by the history
+// when Refresh is called by hand
+const DefaultInterval = time.Second
+
+// StartMonitoring calls Refresh every interval in a goroutine, until ctx is
+// done. If interval isn't positive, the HistoryConfig resolution is used,
+// else DefaultInterval. The history windows are rolled up according to
+// interval, e.g. every 6 refreshes into the hour window with a 10s
+// interval, instead of every 60.
+func (piSugar *PiSugar) StartMonitoring(ctx context.Context, interval time.Duration) {
+	piSugar.mutex.Lock()
+	if interval <= 0 {
+		interval = piSugar.interval
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	piSugar.interval = interval
+	piSugar.mutex.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			piSugar.Refresh()
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// refreshesPer returns the number of refreshes in d, at least 1
+func (piSugar *PiSugar) refreshesPer(d time.Duration) int {
+	interval := piSugar.interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return max(int((d+interval/2)/interval), 1)
+}
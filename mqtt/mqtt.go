@@ -0,0 +1,165 @@
+/*
+   mqtt,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package mqtt publishes PiSugar readings to an MQTT broker, with Home
+// Assistant discovery so they show up as sensors. Like metrics, it lives in
+// its own package so that only users who import it depend on the MQTT client.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	pi_sugar "github.com/peergum/pi-sugar"
+)
+
+// Defaults of the Config fields left empty
+const (
+	DefaultTopic           = "pisugar/state"
+	DefaultDiscoveryPrefix = "homeassistant"
+	DefaultNodeID          = "pisugar"
+	DefaultInterval        = time.Minute
+)
+
+// Config sets where and how often the readings are published
+type Config struct {
+	Topic           string        // state topic, receiving the Status as JSON
+	DiscoveryPrefix string        // Home Assistant discovery prefix
+	NodeID          string        // identifies the device in Home Assistant, one per PiSugar
+	Interval        time.Duration // between two publications
+	NoDiscovery     bool          // don't publish the discovery messages
+}
+
+// Publisher publishes the readings of a PiSugar to a broker. It publishes
+// the values of the last Refresh(), which it leaves to the caller (e.g.
+// with StartMonitoring).
+type Publisher struct {
+	client  paho.Client
+	piSugar *pi_sugar.PiSugar
+	config  Config
+}
+
+// NewPublisher returns a publisher of the readings of piSugar on client,
+// which the caller creates and connects with its own broker options
+func NewPublisher(client paho.Client, piSugar *pi_sugar.PiSugar, config Config) *Publisher {
+	if config.Topic == "" {
+		config.Topic = DefaultTopic
+	}
+	if config.DiscoveryPrefix == "" {
+		config.DiscoveryPrefix = DefaultDiscoveryPrefix
+	}
+	if config.NodeID == "" {
+		config.NodeID = DefaultNodeID
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	return &Publisher{client: client, piSugar: piSugar, config: config}
+}
+
+// Run publishes the discovery messages, then the readings every interval,
+// until ctx is done or a publication fails
+func (publisher *Publisher) Run(ctx context.Context) error {
+	if !publisher.config.NoDiscovery {
+		if err := publisher.PublishDiscovery(); err != nil {
+			return err
+		}
+	}
+	ticker := time.NewTicker(publisher.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := publisher.PublishState(); err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// PublishState publishes the current Snapshot() on the state topic
+func (publisher *Publisher) PublishState() error {
+	payload, err := json.Marshal(publisher.piSugar.Snapshot())
+	if err != nil {
+		return err
+	}
+	return publisher.publish(publisher.config.Topic, false, payload)
+}
+
+// sensor is a Home Assistant entity read from the state topic
+type sensor struct {
+	component   string // sensor or binary_sensor
+	object      string
+	name        string
+	template    string
+	unit        string
+	deviceClass string
+}
+
+var sensors = []sensor{
+	{"sensor", "charge", "Battery", "{{ value_json.charge }}", "%", "battery"},
+	{"sensor", "voltage", "Battery voltage", "{{ value_json.voltage }}", "V", "voltage"},
+	{"sensor", "temperature", "Temperature", "{{ value_json.temperature }}", "°C", "temperature"},
+	{"binary_sensor", "power", "External power", "{{ 'ON' if value_json.power else 'OFF' }}", "", "plug"},
+	{"binary_sensor", "charging", "Charging", "{{ 'ON' if value_json.charging else 'OFF' }}", "", "battery_charging"},
+}
+
+// PublishDiscovery publishes the retained Home Assistant discovery messages
+// declaring the sensors of the PiSugar
+func (publisher *Publisher) PublishDiscovery() error {
+	config := publisher.config
+	device := map[string]any{
+		"identifiers":  []string{config.NodeID},
+		"name":         "PiSugar",
+		"manufacturer": "PiSugar",
+	}
+	for _, sensor := range sensors {
+		message := map[string]any{
+			"name":           sensor.name,
+			"unique_id":      config.NodeID + "_" + sensor.object,
+			"state_topic":    config.Topic,
+			"value_template": sensor.template,
+			"device_class":   sensor.deviceClass,
+			"device":         device,
+		}
+		if sensor.unit != "" {
+			message["unit_of_measurement"] = sensor.unit
+		}
+		payload, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		topic := fmt.Sprintf("%s/%s/%s/%s/config", config.DiscoveryPrefix, sensor.component, config.NodeID, sensor.object)
+		if err := publisher.publish(topic, true, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publish sends payload on topic and waits for the broker
+func (publisher *Publisher) publish(topic string, retained bool, payload []byte) error {
+	token := publisher.client.Publish(topic, 0, retained, payload)
+	token.Wait()
+	return token.Error()
+}
@@ -0,0 +1,125 @@
+/*
+   persistence,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultPersistInterval is the time between saves of the state by Refresh
+// once EnablePersistence was called
+const DefaultPersistInterval = time.Minute
+
+// EnablePersistence restores the state saved at path, if any, then makes
+// Refresh save it there every persist interval (see SetPersistInterval) and
+// as soon as external power is lost, so the history survives a restart or a
+// power cut. The file is written with SaveState and replaced atomically. An
+// empty path stops the saves.
+func (piSugar *PiSugar) EnablePersistence(path string) error {
+	if path != "" {
+		file, err := os.Open(path)
+		switch {
+		case err == nil:
+			err = piSugar.LoadState(file)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("loading %s: %w", path, err)
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return err
+		}
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.persistPath = path
+	piSugar.persisted = time.Now()
+	piSugar.persistedPower = piSugar.power
+	return nil
+}
+
+// SetPersistInterval sets the time between saves of the state by Refresh
+// (DefaultPersistInterval by default). Longer intervals spare the SD card
+// wear at the cost of losing more history on a crash; a power loss is saved
+// right away in any case.
+func (piSugar *PiSugar) SetPersistInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("%w: persist interval %v", ErrOutOfRange, interval)
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.persistInterval = interval
+	return nil
+}
+
+// persist takes a snapshot of the state when a save to the persistence file
+// is due, and returns the function writing it, to be called once mutex is
+// released so the file system doesn't hold up the readers. It returns nil
+// when no save is due.
+func (piSugar *PiSugar) persist(now time.Time) func() {
+	if piSugar.persistPath == "" {
+		return nil
+	}
+	powerLost := piSugar.persistedPower && !piSugar.power
+	piSugar.persistedPower = piSugar.power
+	if !powerLost && now.Sub(piSugar.persisted) < piSugar.persistInterval {
+		return nil
+	}
+	piSugar.persisted = now
+	path, state := piSugar.persistPath, piSugar.savedState().clone()
+	return func() {
+		piSugar.persistMutex.Lock()
+		defer piSugar.persistMutex.Unlock()
+		if err := writeStateFile(path, state); err != nil {
+			warn("Can't save state to %s %v", path, err)
+		}
+	}
+}
+
+// writeStateFile replaces the file at path with state, through a temporary
+// file so a power cut never leaves it half written
+func writeStateFile(path string, state savedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+/*
+   persistence_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// savedCounter returns the refresh counter saved at path, or -1 when there
+// is no file
+func savedCounter(t *testing.T, path string) int {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return -1
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	loaded, _ := newTestPiSugar(time.Second)
+	if err := loaded.LoadState(file); err != nil {
+		t.Fatal(err)
+	}
+	return loaded.counter
+}
+
+func TestPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	piSugar, device := newTestPiSugar(time.Second)
+	piSugar.persistInterval = DefaultPersistInterval
+	device.registers[batteryChargeReg] = 50
+	device.registers[powerReg] = powerExternalMask
+	if err := piSugar.EnablePersistence(path); err != nil {
+		t.Fatal(err)
+	}
+	piSugar.Refresh()
+	if got := savedCounter(t, path); got != -1 {
+		t.Errorf("saved before the persist interval, counter %d", got)
+	}
+
+	// a power loss is saved right away
+	device.registers[powerReg] = 0
+	piSugar.Refresh()
+	if got := savedCounter(t, path); got != 2 {
+		t.Errorf("saved counter after power loss = %d, want 2", got)
+	}
+
+	if err := piSugar.SetPersistInterval(time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	piSugar.Refresh()
+	if got := savedCounter(t, path); got != 3 {
+		t.Errorf("saved counter after the interval = %d, want 3", got)
+	}
+	if err := piSugar.SetPersistInterval(0); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("SetPersistInterval(0) error = %v, want ErrOutOfRange", err)
+	}
+}
+
+func TestPersistUnlocked(t *testing.T) {
+	piSugar, _ := newTestPiSugar(time.Second)
+	piSugar.persistPath = filepath.Join(t.TempDir(), "state.json")
+	save := piSugar.persist(time.Now())
+	if save == nil {
+		t.Fatal("no save due")
+	}
+	// the snapshot doesn't follow the history, nor needs mutex
+	piSugar.Refresh()
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	save()
+	if got := savedCounter(t, piSugar.persistPath); got != 0 {
+		t.Errorf("saved counter = %d, want the snapshot's 0", got)
+	}
+}
@@ -19,28 +19,197 @@
 package pi_sugar
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/peergum/go-rpio/v5"
-	"log"
+	"os"
+	"sync"
+	"time"
 )
 
+// PowerSource tells where the external power feeding the PiSugar comes from
+type PowerSource int
+
+const (
+	PowerSourceNone PowerSource = iota // running on battery
+	PowerSourceUSB                     // USB-C port
+	PowerSourceGPIO                    // 5V GPIO pins
+)
+
+// powerSourceNames are the text forms of the power sources
+var powerSourceNames = map[PowerSource]string{
+	PowerSourceNone: "None",
+	PowerSourceUSB:  "USB",
+	PowerSourceGPIO: "GPIO",
+}
+
+// String returns the name of the power source
+func (source PowerSource) String() string {
+	return enumString(source, powerSourceNames)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (source PowerSource) MarshalText() ([]byte, error) {
+	return enumMarshal(source, powerSourceNames)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (source *PowerSource) UnmarshalText(text []byte) error {
+	return enumUnmarshal(text, powerSourceNames, source)
+}
+
+// i2c is the part of the I2C device used by PiSugar, so the board can be
+// replaced by a fake returning canned register values
+type i2c interface {
+	I2cReadRegister(regAddr uint32, buf []byte, len uint32) int
+	I2cWrite(data ...byte) int
+	I2cSetSlaveAddress(address uint32)
+	I2cEnd()
+}
+
+// PiSugar holds the readings of the board and their history. It is safe for
+// concurrent use: Refresh() updates it under a lock, and the getters read
+// under the same lock, so they can be called from any goroutine. Use
+// Snapshot() to get several readings from the same Refresh().
 type PiSugar struct {
-	voltage     float64
-	charge      int
-	power       bool
-	charging    bool
-	model       int
-	temperature int
-	*rpio.I2cDevice
+	voltage           float64
+	inputVoltage      float64
+	charge            int
+	power             bool
+	powerSource       PowerSource
+	charging          bool
+	full              bool
+	critical          bool
+	model             Model
+	temperature       int
+	temperatureOffset int
+	current           float64      // charging current (mA), with CurrentSense
+	mutex             sync.RWMutex // protects history and I2C transfers
+	i2c
+
+	historyConfig         HistoryConfig
+	lastMinuteCharge      []Sample
+	lastHourCharge        []Sample
+	lastDayCharge         []Sample
+	lastMinuteVoltage     []Sample
+	lastHourVoltage       []Sample
+	lastDayVoltage        []Sample
+	lastMinuteTemperature []Sample
+	lastHourTemperature   []Sample
+	lastDayTemperature    []Sample
+	counter               int
+	aggregations          [numberOfMetrics][LastDays + 1]AggFn // nil is Mean
+
+	events         chan Event
+	eventState     eventState
+	eventStateSeen bool
+	powerChanged   chan struct{} // closed on power transitions
+	subscribers    map[chan Status]struct{}
+	eventLog       []Event
+	eventLogSize   int
+
+	lowBatteryHooks []*lowBatteryHook
+	buttonHandlers  map[ButtonAction][]func()
+
+	powerChangeHooks []func(powered bool)
+	powerDebounced   bool // power state last reported to the hooks
+	powerSeen        bool // powerDebounced is set
+	powerDiffering   int  // consecutive readings differing from powerDebounced
+
+	interval time.Duration // between refreshes, set by StartMonitoring
+
+	batteryCurve []CurvePoint // voltage to charge, sorted by voltage
+
+	previousCharge    float64
+	chargeIn          float64
+	chargeOut         float64
+	throughputStarted bool
+
+	watchdog bool // feed the firmware watchdog on each refresh
+
+	outlierRejection bool
+	outlierThreshold int
+	outliers         int // consecutive outliers seen
+
+	minVoltage        float64 // plausible battery voltage range
+	maxVoltage        float64
+	voltageRejections int
+
+	systemdNotify bool // notify systemd on each healthy refresh
+	systemdReady  bool // READY=1 already sent
+
+	block []byte // registers blockFirstReg to blockLastReg, during Refresh
+
+	lastRefresh time.Time // last successful refresh
+	lastError   error     // error of the last refresh, nil if it succeeded
+
+	faults Faults
+
+	retries      int // extra attempts for failed transfers
+	retryBackoff time.Duration
+
+	transferTimeout time.Duration
+	stuck           chan struct{} // closed when a timed out transfer returns
+
+	writeEnableDepth int // nesting of writeEnabled calls
+
+	bus rpio.I2cNum // of i2c, noBus when it isn't a numbered bus
+
+	persistPath     string        // file saving the state, set by EnablePersistence
+	persistInterval time.Duration // time between saves to persistPath
+	persisted       time.Time     // last save to persistPath
+	persistMutex    sync.Mutex    // serializes the writes of persistPath, done without mutex
+	persistedPower  bool          // external power at the previous refresh
 }
 
+// PiSugar 3 registers. The address, powerReg bit 7, temperatureReg,
+// voltageReg and batteryChargeReg are the ones read by the original driver.
+// The others have no datasheet or pisugar-server source yet and are only
+// used by the features Capabilities enables, except powerReg bit 6 which
+// charging detection reads (never writes) pending one.
 const (
 	piSugarI2CAddress = 0x57
 
-	powerReg         = 0x02
-	temperatureReg   = 0x04
-	voltageReg       = 0x22
-	batteryChargeReg = 0x2a
-	//chargingStatusReg
+	powerReg          = 0x02
+	temperatureReg    = 0x04
+	voltageReg        = 0x22
+	inputVoltageReg   = 0x24             // unconfirmed
+	autoShutdownReg   = 0x0a             // unconfirmed, battery percentage below which the board cuts power
+	firmwareReg       = 0xe0             // unconfirmed, firmware version, major then minor
+	blockFirstReg     = powerReg         // first register read by loadBlock
+	blockLastReg      = batteryChargeReg // last register read by loadBlock
+	batteryChargeReg  = 0x2a
+	chargeFractionReg = 0x2b  // unconfirmed, 1/256 of percent, with ChargeFraction
+	registerCount     = 0x100 // registers have 8-bit addresses, transfers can't go past them
+
+	// powerReg bits, all read in one transfer so they are consistent:
+	//   7: external power present
+	//   6: battery charging, unconfirmed
+	//   5: external power comes from the GPIO pins (else USB-C), unconfirmed
+	//   1: battery full, unconfirmed
+	//   0: battery critically low, unconfirmed
+	powerExternalMask = 0x80
+	powerChargingMask = 0x40
+	powerGPIOMask     = 0x20
+	powerFullMask     = 0x02
+	powerCriticalMask = 0x01
+
+	// DefaultTemperatureOffset is added to the raw temperature register
+	// (unsigned byte) to get degrees Celsius
+	DefaultTemperatureOffset = -40
+
+	// DefaultTransferTimeout bounds I2C transfers, which take well under a
+	// millisecond per byte at 100kHz
+	DefaultTransferTimeout = 100 * time.Millisecond
+
+	// reason codes returned by go-rpio transfers
+	i2cReasonNACK                = 0x01
+	i2cReasonClockStretchTimeout = 0x02
+	i2cReasonData                = 0x04 // fewer bytes transferred than requested
+	i2cReasonTimeout             = 0x08 // the controller gave up waiting for the transfer
+	i2cReasonBackend             = 0x80 // other failure, see transferError
+
 	secondsInAMinute = 60
 	minutesInAnHour  = 60
 	hoursInADay      = 24
@@ -48,138 +217,845 @@ const (
 )
 
 var (
-	piSugar               PiSugar
-	lastMinuteCharge      []int     = make([]int, 0, secondsInAMinute)
-	lastHourCharge        []float64 = make([]float64, 0, minutesInAnHour)
-	lastDayCharge         []float64 = make([]float64, 0, hoursInADay*numberOfDays)
-	lastMinuteVoltage     []float64 = make([]float64, 0, secondsInAMinute)
-	lastHourVoltage       []float64 = make([]float64, 0, minutesInAnHour)
-	lastDayVoltage        []float64 = make([]float64, 0, hoursInADay*numberOfDays)
-	lastMinuteTemperature []int     = make([]int, 0, secondsInAMinute)
-	lastHourTemperature   []float64 = make([]float64, 0, minutesInAnHour)
-	lastDayTemperature    []float64 = make([]float64, 0, hoursInADay*numberOfDays)
-	counter               int
+	piSugar = PiSugar{
+		historyConfig:     DefaultHistoryConfig(),
+		temperatureOffset: DefaultTemperatureOffset,
+		eventLogSize:      DefaultEventLogSize,
+		transferTimeout:   DefaultTransferTimeout,
+		persistInterval:   DefaultPersistInterval,
+		minVoltage:        DefaultMinVoltage,
+		maxVoltage:        DefaultMaxVoltage,
+	}
+	initMutex   sync.Mutex // serializes Init(), InitBus(), InitContext() and End()
+	initDone    bool       // the bring-up completed, with initErr as result
+	initErr     error
+	initialized bool
+	rpioOpened  bool          // rpio.Open() succeeded, to be paired with rpio.Close()
+	abandoned   chan struct{} // closed once a bring-up given up by initBus is released
+
+	ErrBufferSize     = errors.New("buffer too small for register read")
+	ErrOutOfRange     = errors.New("value out of range")
+	ErrNoDevice       = errors.New("no PiSugar found on the I2C bus")
+	ErrNotInitialized = errors.New("PiSugar not initialized")
+	ErrUnsupported    = errors.New("not supported by this PiSugar model")
+	ErrNotMapped      = errors.New("can't map the I2C registers, run as root (e.g. with sudo)")
+
+	ErrNACK                = errors.New("I2C transfer not acknowledged")
+	ErrClockStretchTimeout = errors.New("I2C clock stretch timeout")
+	ErrShortTransfer       = errors.New("I2C transfer ended early")
+	ErrI2cTimeout          = errors.New("I2C transfer timed out")
 )
 
+// Init opens the GPIO memory and starts the I2C device of the PiSugar.
+// Calling it again once it succeeded does nothing; call End() first to
+// restart from scratch.
 func Init() (err error) {
-	if err = rpio.Open(); err != nil {
-		log.Printf("Can't open rpio %v", err)
+	return InitContext(context.Background())
+}
+
+// InitContext is Init with a deadline: if ctx is done before the bus is
+// up and the board answered, it returns ctx.Err(). The bring-up itself
+// can't be interrupted, so it is released in the background when it
+// eventually completes.
+func InitContext(ctx context.Context) (err error) {
+	return initBus(ctx, rpio.I2c1, ModelUnknown, BackendRPIO)
+}
+
+// InitBus is Init for a PiSugar on another I2C bus than I2c1 (e.g. on a
+// Compute Module or with a dtoverlay remap). go-rpio only drives I2c1, so
+// other buses return ErrUnsupported: use InitBusWithBackend with
+// BackendDevI2C for them.
+func InitBus(dev rpio.I2cNum) error {
+	return initBus(context.Background(), dev, ModelUnknown, BackendRPIO)
+}
+
+// InitModel is InitBus for a known board model, skipping the detection.
+// ModelUnknown detects it, as Init does.
+func InitModel(dev rpio.I2cNum, model Model) error {
+	return initBus(context.Background(), dev, model, BackendRPIO)
+}
+
+// InitWithBackend is Init going through backend, e.g. BackendDevI2C to run
+// without root next to other users of the kernel I2C driver
+func InitWithBackend(backend Backend) error {
+	return initBus(context.Background(), rpio.I2c1, ModelUnknown, backend)
+}
+
+// InitBusWithBackend is InitModel going through backend. BackendDevI2C
+// opens /dev/i2c-N for bus N, so it works on any bus the kernel exposes.
+func InitBusWithBackend(dev rpio.I2cNum, model Model, backend Backend) error {
+	return initBus(context.Background(), dev, model, backend)
+}
+
+// initBus brings up the PiSugar on bus dev, giving up when ctx is done.
+// Concurrent callers are serialized and only the first one performs the
+// bring-up: the others, and any later call until End(), get its result.
+// A bring-up given up because of ctx isn't recorded, so it can be retried,
+// once the hardware calls it was waiting for returned.
+func initBus(ctx context.Context, dev rpio.I2cNum, model Model, backend Backend) (err error) {
+	initMutex.Lock()
+	for abandoned != nil && !initDone {
+		pending := abandoned
+		initMutex.Unlock()
+		select {
+		case <-pending:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		initMutex.Lock()
+	}
+	if initDone {
+		initMutex.Unlock()
+		return initErr
+	}
+	if err = ctx.Err(); err != nil {
+		initMutex.Unlock()
 		return err
 	}
+	done := make(chan beginResult, 1)
+	go func() {
+		up, err := begin(dev, model, backend)
+		done <- beginResult{up, err}
+	}()
+	select {
+	case result := <-done:
+		if result.err == nil {
+			result.up.publish()
+		}
+		initDone, initErr = true, result.err
+		initialized = result.err == nil
+		initMutex.Unlock()
+		return result.err
+	case <-ctx.Done():
+		// End() and IsInitialized() go on, the next bring-up waits until
+		// this one is released
+		pending := make(chan struct{})
+		abandoned = pending
+		initMutex.Unlock()
+		go func() {
+			if result := <-done; result.err == nil {
+				result.up.release()
+			}
+			initMutex.Lock()
+			abandoned = nil
+			initMutex.Unlock()
+			close(pending)
+		}()
+		return ctx.Err()
+	}
+}
 
-	if piSugar.I2cDevice, err = rpio.I2cBegin(rpio.I2c1, piSugarI2CAddress); err != nil {
-		log.Printf("Can't start I2C %v", err)
-		return err
+// bringUp is a device brought up by begin, only given to piSugar once the
+// whole bring-up succeeded
+type bringUp struct {
+	device i2c
+	bus    rpio.I2cNum
+	model  Model
+	rpio   bool // rpio.Open() succeeded, to be paired with rpio.Close()
+}
+
+// beginResult is what begin returns, sent through a channel
+type beginResult struct {
+	up  bringUp
+	err error
+}
+
+// publish makes up the device of piSugar
+func (up bringUp) publish() {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.i2c, piSugar.bus, piSugar.model = up.device, up.bus, up.model
+	rpioOpened = up.rpio
+}
+
+// release ends the device and unmaps the GPIO memory of a bring-up that
+// won't be published
+func (up bringUp) release() {
+	if up.device != nil {
+		up.device.I2cEnd()
 	}
-	piSugar.I2cSetSlaveAddress(0x57)
-	//piSugar.I2cSetBaudrate(110000)
-	return nil
+	if up.rpio {
+		if err := rpio.Close(); err != nil {
+			warn("Can't close rpio %v", err)
+		}
+	}
+}
+
+// begin brings up the I2C bus dev through backend and probes the board,
+// detecting its model if it is ModelUnknown. Whatever was set up is
+// released if it fails.
+func begin(dev rpio.I2cNum, model Model, backend Backend) (up bringUp, err error) {
+	defer func() {
+		if err != nil {
+			up.release()
+			up = bringUp{}
+		}
+	}()
+	switch backend {
+	case BackendRPIO:
+		if dev != rpio.I2c1 {
+			// go-rpio maps and wires the BSC1 controller only
+			return up, fmt.Errorf("%w: I2C bus %d through go-rpio", ErrUnsupported, dev)
+		}
+		up.rpio, err = rpioOpen()
+		if err == nil {
+			var device *rpio.I2cDevice
+			if device, err = rpio.I2cBegin(dev, piSugarI2CAddress); err == nil {
+				up.device = device
+			}
+		}
+	case BackendDevI2C:
+		var device i2cReader
+		if device, err = openDevI2C(dev, piSugarI2CAddress); err == nil {
+			up.device = device
+		}
+	default:
+		err = fmt.Errorf("%w: backend %d", ErrOutOfRange, backend)
+	}
+	if err != nil {
+		warn("Can't start I2C %v", err)
+		return up, err
+	}
+
+	// the board is probed through a PiSugar of its own, so piSugar is only
+	// touched by publish
+	piSugar.mutex.RLock()
+	candidate := &PiSugar{
+		i2c:             up.device,
+		transferTimeout: piSugar.transferTimeout,
+		retries:         piSugar.retries,
+		retryBackoff:    piSugar.retryBackoff,
+	}
+	piSugar.mutex.RUnlock()
+	if model == ModelUnknown {
+		model, err = candidate.probeModel()
+	} else {
+		err = candidate.probe(model)
+	}
+	if err != nil {
+		warn("PiSugar not responding %v", err)
+		return up, fmt.Errorf("%w: %w", ErrNoDevice, err)
+	}
+	up.bus, up.model = dev, model
+	Debug("Model %v detected", model)
+	return up, nil
+}
+
+// rpioOpen maps the GPIO memory with go-rpio, telling whether it did
+func rpioOpen() (bool, error) {
+	if err := rpio.Open(); err != nil {
+		if os.IsPermission(err) || os.Geteuid() != 0 {
+			return false, fmt.Errorf("%w: %w", ErrNotMapped, err)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// IsInitialized tells whether Init() succeeded and End() wasn't called since
+func IsInitialized() bool {
+	initMutex.Lock()
+	defer initMutex.Unlock()
+	return initialized
 }
 
+// End releases the I2C device and unmaps the GPIO memory mapped by Init(),
+// so Init() and End() can be paired any number of times. It is safe to call
+// when Init() failed or more than once, so it can always be deferred.
+// After End(), Init() performs the bring-up again, even if it failed before.
 func End() {
-	piSugar.I2cEnd()
+	initMutex.Lock()
+	defer initMutex.Unlock()
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if piSugar.i2c != nil {
+		piSugar.I2cEnd()
+		piSugar.i2c = nil
+	}
+	if rpioOpened {
+		if err := rpio.Close(); err != nil {
+			warn("Can't close rpio %v", err)
+		}
+		rpioOpened = false
+	}
+	initDone, initErr = false, nil
+	initialized = false
 }
 
 func NewPiSugar() (*PiSugar, error) {
 	return &piSugar, nil
 }
 
+// NewPiSugarWithHistory returns the PiSugar using the given history buffer
+// sizes, or resolution and retention, instead of the default ones. Any
+// history already collected is dropped.
+func NewPiSugarWithHistory(config HistoryConfig) (*PiSugar, error) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.setHistoryConfig(config)
+	return &piSugar, nil
+}
+
 func (piSugar *PiSugar) Voltage() float64 {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
 	return piSugar.voltage
 }
 
 func (piSugar *PiSugar) Charge() int {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
 	return piSugar.charge
 }
 
+// ChargeFloat returns the battery charge averaged over the last minute,
+// without rounding it down to an integer percentage like Charge()
+func (piSugar *PiSugar) ChargeFloat() float64 {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return avgSamples(piSugar.lastMinuteCharge)
+}
+
+// Temperature returns the board temperature in °C, averaged over the last
+// minute
+func (piSugar *PiSugar) Temperature() int {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.temperature
+}
+
+// SetTemperatureOffset calibrates the temperature conversion: offset is added
+// to the raw register value (DefaultTemperatureOffset unless changed).
+// It applies to the samples read from now on.
+func (piSugar *PiSugar) SetTemperatureOffset(offset int) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.temperatureOffset = offset
+}
+
+// convertTemperature turns the raw temperature register into °C
+func (piSugar *PiSugar) convertTemperature(raw byte) int {
+	return int(raw) + piSugar.temperatureOffset
+}
+
+// Charging tells whether the battery was charging at the last Refresh(),
+// which needs external power, see ChargeState()
 func (piSugar *PiSugar) Charging() bool {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
 	return piSugar.charging
 }
 
 func (piSugar *PiSugar) Power() bool {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
 	return piSugar.power
 }
 
-func appendInt(table []int, value int, maxSize int) []int {
-	firstElement := 0
-	if len(table) == maxSize {
-		firstElement = 1
+// LastRefresh returns the time of the last successful Refresh() and the
+// error of the last one (nil if it succeeded), to detect stale readings
+func (piSugar *PiSugar) LastRefresh() (time.Time, error) {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.lastRefresh, piSugar.lastError
+}
+
+// HasData tells whether Refresh() got at least one voltage reading; until
+// then the getters return zero values
+func (piSugar *PiSugar) HasData() bool {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return len(piSugar.lastMinuteVoltage) > 0
+}
+
+// VoltageRaw returns the last voltage sample, without averaging
+func (piSugar *PiSugar) VoltageRaw() float64 {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	if n := len(piSugar.lastMinuteVoltage); n > 0 {
+		return piSugar.lastMinuteVoltage[n-1].Value
 	}
-	table = append(table[firstElement:], value)
-	return table
+	return 0
 }
 
-func appendFloat64(table []float64, value float64, maxSize int) []float64 {
+// ChargeRaw returns the last charge sample, without averaging
+func (piSugar *PiSugar) ChargeRaw() int {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	if n := len(piSugar.lastMinuteCharge); n > 0 {
+		return int(piSugar.lastMinuteCharge[n-1].Value)
+	}
+	return 0
+}
+
+// TemperatureRaw returns the last temperature sample, without averaging
+func (piSugar *PiSugar) TemperatureRaw() int {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	if n := len(piSugar.lastMinuteTemperature); n > 0 {
+		return int(piSugar.lastMinuteTemperature[n-1].Value)
+	}
+	return 0
+}
+
+// IsFull reports the firmware "battery full" flag. Unlike Charge(), which is
+// averaged over the last minute and may stay just below 100, this flag is set
+// as soon as the charger terminates, so prefer it for "stop charging" logic.
+func (piSugar *PiSugar) IsFull() bool {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.full
+}
+
+// IsCritical reports the firmware "battery critically low" flag, raised
+// right before the board cuts power whatever Charge() says. Use Charge()
+// for early warnings and IsCritical() as the last call to shut down.
+func (piSugar *PiSugar) IsCritical() bool {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.critical
+}
+
+// InputVoltage returns the voltage of the external power source (0 on battery)
+func (piSugar *PiSugar) InputVoltage() float64 {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.inputVoltage
+}
+
+// PowerSource returns where the external power comes from
+func (piSugar *PiSugar) PowerSource() PowerSource {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.powerSource
+}
+
+// appendSample appends value to table, dropping the oldest samples to keep
+// at most maxSize of them
+func appendSample(table []Sample, value float64, at time.Time, maxSize int) []Sample {
+	if maxSize <= 0 {
+		return table
+	}
 	firstElement := 0
-	if len(table) == maxSize {
-		firstElement = 1
+	if len(table) >= maxSize {
+		firstElement = len(table) - maxSize + 1
 	}
-	table = append(table[firstElement:], value)
+	table = append(table[firstElement:], Sample{Time: at, Value: value})
 	return table
 }
 
-func avgInt(table []int) (avg float64) {
+// registerValue decodes the big-endian register pair at the start of buf,
+// as unsigned (uint16) or two's complement (int16)
+func registerValue[T int16 | uint16](buf []byte) T {
+	return T(uint16(buf[0])<<8 | uint16(buf[1]))
+}
+
+// volts converts the millivolts register pair at the start of buf to volts
+func volts(buf []byte) float64 {
+	return float64(registerValue[uint16](buf)) / 1000
+}
+
+// tail returns the last n samples of table, or all of them if it holds less
+func tail(table []Sample, n int) []Sample {
+	return table[max(len(table)-n, 0):]
+}
+
+// avgSamples returns the average value of table, or 0 if it is empty
+func avgSamples(table []Sample) (avg float64) {
+	if len(table) == 0 {
+		return 0
+	}
 	for _, v := range table {
-		avg += float64(v)
+		avg += v.Value
 	}
 	return avg / float64(len(table))
 }
 
-func avgFloat64(table []float64) (avg float64) {
-	for _, v := range table {
-		avg += v
+// reasonError turns a go-rpio reason code into an error
+func reasonError(code int) error {
+	if code&i2cReasonNACK != 0 {
+		return ErrNACK
 	}
-	return avg / float64(len(table))
+	if code&i2cReasonClockStretchTimeout != 0 {
+		return ErrClockStretchTimeout
+	}
+	if code&i2cReasonData != 0 {
+		return ErrShortTransfer
+	}
+	if code&i2cReasonTimeout != 0 {
+		return ErrI2cTimeout
+	}
+	return fmt.Errorf("I2C error code %d", code)
+}
+
+// backendErrorer is a device telling why its last transfer failed, when a
+// reason code can't
+type backendErrorer interface {
+	backendError() error
+}
+
+// transferError returns the error of a failed transfer: the one of the
+// backend if it has one, else the one of code
+func (piSugar *PiSugar) transferError(code int) error {
+	if device, ok := piSugar.i2c.(backendErrorer); ok && code&i2cReasonBackend != 0 {
+		if err := device.backendError(); err != nil {
+			return err
+		}
+	}
+	return reasonError(code)
+}
+
+// SetRetry makes register reads and writes retry up to attempts times in
+// total, waiting backoff between attempts, before reporting a failure
+// (e.g. a NACK on long cables). The default is a single attempt.
+func (piSugar *PiSugar) SetRetry(attempts int, backoff time.Duration) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.retries = max(attempts-1, 0)
+	piSugar.retryBackoff = max(backoff, 0)
+}
+
+// transfer runs an I2C transfer reading into buf (nil for a write),
+// retrying it as set by SetRetry, and returns the go-rpio reason code of
+// the last attempt, or ErrI2cTimeout
+func (piSugar *PiSugar) transfer(buf []byte, do func(buf []byte) int) (code int, err error) {
+	return piSugar.transferContext(context.Background(), buf, do)
+}
+
+// transferContext is transfer giving up when ctx is done, before an attempt
+// or while waiting between attempts. A transfer in progress can't be
+// interrupted, as go-rpio busy-waits on the controller.
+func (piSugar *PiSugar) transferContext(ctx context.Context, buf []byte, do func(buf []byte) int) (code int, err error) {
+	for attempt := 0; ; attempt++ {
+		if err = ctx.Err(); err != nil {
+			return code, err
+		}
+		if code, err = piSugar.attempt(buf, do); err != nil || code == 0 || attempt >= piSugar.retries {
+			return code, err
+		}
+		Debug("I2C transfer failed (code %d), retrying", code)
+		select {
+		case <-time.After(piSugar.retryBackoff):
+		case <-ctx.Done():
+			return code, ctx.Err()
+		}
+	}
+}
+
+// attempt runs do, giving up with ErrI2cTimeout after the transfer timeout.
+// go-rpio can't abort a transfer, so a timed out one is left running and
+// the following ones fail with ErrI2cTimeout until it returns. do reads
+// into a buffer of its own, copied to buf when it succeeds in time, so a
+// timed out transfer can't write into buf after attempt returned.
+func (piSugar *PiSugar) attempt(buf []byte, do func(buf []byte) int) (int, error) {
+	if piSugar.stuck != nil {
+		select {
+		case <-piSugar.stuck:
+			piSugar.stuck = nil
+		default:
+			return 0, ErrI2cTimeout
+		}
+	}
+	if piSugar.transferTimeout <= 0 {
+		return do(buf), nil
+	}
+	own := make([]byte, len(buf))
+	done := make(chan int, 1)
+	go func() {
+		done <- do(own)
+	}()
+	timer := time.NewTimer(piSugar.transferTimeout)
+	defer timer.Stop()
+	select {
+	case code := <-done:
+		if code == 0 {
+			copy(buf, own)
+		}
+		return code, nil
+	case <-timer.C:
+		warn("I2C transfer still running after %v", piSugar.transferTimeout)
+		stuck := make(chan struct{})
+		go func() {
+			<-done
+			close(stuck)
+		}()
+		piSugar.stuck = stuck
+		return 0, ErrI2cTimeout
+	}
+}
+
+// SetTransferTimeout sets how long an I2C transfer may take before failing
+// with ErrI2cTimeout, e.g. when the board is unplugged in the middle of it
+// (DefaultTransferTimeout by default, 0 waits forever)
+func (piSugar *PiSugar) SetTransferTimeout(timeout time.Duration) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.transferTimeout = max(timeout, 0)
+}
+
+// readRegister reads n bytes from register reg into buf, refusing reads that
+// would not fit in buf
+func (piSugar *PiSugar) readRegister(reg byte, buf []byte, n int) error {
+	return piSugar.readRegisterContext(context.Background(), reg, buf, n)
+}
+
+// readRegisterContext is readRegister giving up when ctx is done
+func (piSugar *PiSugar) readRegisterContext(ctx context.Context, reg byte, buf []byte, n int) error {
+	if piSugar.i2c == nil {
+		return ErrNotInitialized
+	}
+	if n < 0 || n > len(buf) {
+		return fmt.Errorf("%w: %d bytes requested, buffer holds %d", ErrBufferSize, n, len(buf))
+	}
+	code, err := piSugar.transferContext(ctx, buf[:n], func(buf []byte) int {
+		return piSugar.I2cReadRegister(uint32(reg), buf, uint32(len(buf)))
+	})
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("reading register 0x%02x failed: %w", reg, piSugar.transferError(code))
+	}
+	return nil
+}
+
+// String returns a one-line summary of the last readings
+func (piSugar *PiSugar) String() string {
+	return piSugar.Snapshot().String()
+}
+
+// StatusLine returns String() followed by the charging state
+func (piSugar *PiSugar) StatusLine() string {
+	return piSugar.Snapshot().StatusLine()
+}
+
+// writeRegister writes data to the registers starting at reg, unlocking
+// them first on a PiSugar 3 (see writeEnabled)
+func (piSugar *PiSugar) writeRegister(reg byte, data ...byte) error {
+	if piSugar.model.isPiSugar3() && reg != writeEnableReg {
+		return piSugar.writeEnabled(func() error {
+			return piSugar.writeRegisterRaw(reg, data...)
+		})
+	}
+	return piSugar.writeRegisterRaw(reg, data...)
+}
+
+// writeRegisterRaw is writeRegister without the unlocking
+func (piSugar *PiSugar) writeRegisterRaw(reg byte, data ...byte) error {
+	if piSugar.i2c == nil {
+		return ErrNotInitialized
+	}
+	code, err := piSugar.transfer(nil, func([]byte) int {
+		return piSugar.I2cWrite(append([]byte{reg}, data...)...)
+	})
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("writing register 0x%02x failed: %w", reg, piSugar.transferError(code))
+	}
+	return nil
+}
+
+// loadBlock reads all the registers used by Refresh in one transfer, on
+// models where they can be read as a block. On failure, or on other models,
+// readRefreshRegister falls back to individual reads.
+func (piSugar *PiSugar) loadBlock() {
+	piSugar.block = nil
+	if !piSugar.model.isPiSugar3() {
+		return
+	}
+	var block = make([]byte, blockLastReg-blockFirstReg+1)
+	if err := piSugar.readRegister(blockFirstReg, block, len(block)); err != nil {
+		Debug("Block read failed, reading registers one by one: %v", err)
+		return
+	}
+	piSugar.block = block
+}
+
+// readRefreshRegister reads n bytes from register reg into buf, from the
+// block loaded by loadBlock when it holds them
+func (piSugar *PiSugar) readRefreshRegister(reg byte, buf []byte, n int) error {
+	if piSugar.block != nil && reg >= blockFirstReg && int(reg)+n <= blockLastReg+1 && n >= 0 && n <= len(buf) {
+		copy(buf, piSugar.block[reg-blockFirstReg:int(reg-blockFirstReg)+n])
+		return nil
+	}
+	return piSugar.readRegister(reg, buf, n)
+}
+
+// ReadRegister reads n bytes starting at register reg. It returns
+// ErrOutOfRange if n is negative or the read would go past register 0xff.
+//
+// Advanced: this is a raw escape hatch for debugging or for firmware features
+// not covered by the typed methods. Nothing is validated against the board
+// model.
+func (piSugar *PiSugar) ReadRegister(reg byte, n int) ([]byte, error) {
+	return piSugar.ReadRegisterContext(context.Background(), reg, n)
+}
+
+// ReadRegisterContext is ReadRegister giving up when ctx is done before
+// the transfer or between retries (see SetRetry)
+func (piSugar *PiSugar) ReadRegisterContext(ctx context.Context, reg byte, n int) ([]byte, error) {
+	if n < 0 || int(reg)+n > registerCount {
+		return nil, fmt.Errorf("%w: %d bytes from register 0x%02x", ErrOutOfRange, n, reg)
+	}
+	var buf = make([]byte, n)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if err := piSugar.readRegisterContext(ctx, reg, buf, n); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteRegister writes data to the registers starting at reg. Writing no
+// data is a no-op.
+//
+// Unsafe: writing the wrong register can change the board configuration or
+// cut the power to the Pi. Use the typed methods whenever possible.
+func (piSugar *PiSugar) WriteRegister(reg byte, data []byte) error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	return piSugar.writeRegisterChecked(reg, data)
+}
+
+// writeRegisterChecked is WriteRegister, the caller holding the mutex
+func (piSugar *PiSugar) writeRegisterChecked(reg byte, data []byte) error {
+	if int(reg)+len(data) > registerCount {
+		return fmt.Errorf("%w: %d bytes from register 0x%02x", ErrOutOfRange, len(data), reg)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return piSugar.writeRegister(reg, data...)
+}
+
+// setRegisterBits sets (or clears) the bits of mask in register reg
+func (piSugar *PiSugar) setRegisterBits(reg byte, mask byte, set bool) error {
+	var buf = make([]byte, 1)
+	if err := piSugar.readRegister(reg, buf, 1); err != nil {
+		return err
+	}
+	if set {
+		buf[0] |= mask
+	} else {
+		buf[0] &^= mask
+	}
+	return piSugar.writeRegister(reg, buf[0])
 }
 
 func (piSugar *PiSugar) Refresh() {
 	var buf []byte = make([]byte, 2)
-	counter++
-
-	// we keep history of each variable
-	// 60 last seconds
-	// 60 last minutes
-	// "numberOfDays" last days
-	code := piSugar.I2cReadRegister(temperatureReg, buf, 1)
-	if code == 0 {
-		lastMinuteTemperature = appendInt(lastMinuteTemperature, int(buf[0])-40, secondsInAMinute)
-		piSugar.temperature = int(avgInt(lastMinuteTemperature))
-		if counter%60 == 0 {
-			lastHourTemperature = appendFloat64(lastHourTemperature, avgInt(lastMinuteTemperature), minutesInAnHour)
-			if counter%1440 == 0 {
-				lastDayTemperature = appendFloat64(lastDayTemperature, avgFloat64(lastHourTemperature), numberOfDays*hoursInADay)
+	var save func() // deferred before the unlock, so it runs after it
+	defer func() {
+		if save != nil {
+			save()
+		}
+	}()
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.counter++
+	config := piSugar.historyConfig
+	now := time.Now()
+	var failed error // last failed read, if any
+	registers := piSugar.model.registers()
+	perMinute := piSugar.refreshesPer(time.Minute)
+	perHour := perMinute * minutesInAnHour
+	if sim, ok := piSugar.i2c.(*simulator); ok {
+		sim.tick()
+	}
+	piSugar.loadBlock()
+	defer func() {
+		piSugar.block = nil
+	}()
+
+	// we keep history of each variable (sizes from HistoryConfig)
+	// one sample per refresh
+	// one per minute, from the refreshes of the minute
+	// one per hour, from the minutes of the hour
+	if registers.temperature != 0 {
+		if err := piSugar.readRefreshRegister(registers.temperature, buf, 1); err == nil {
+			piSugar.lastMinuteTemperature = appendSample(piSugar.lastMinuteTemperature, float64(piSugar.convertTemperature(buf[0])), now, config.Minute)
+			piSugar.temperature = int(avgSamples(piSugar.lastMinuteTemperature))
+			if piSugar.counter%perMinute == 0 {
+				piSugar.lastHourTemperature = appendSample(piSugar.lastHourTemperature, piSugar.aggregate(MetricTemperature, LastHour, tail(piSugar.lastMinuteTemperature, perMinute)), now, config.Hour)
+				if piSugar.counter%perHour == 0 {
+					piSugar.lastDayTemperature = appendSample(piSugar.lastDayTemperature, piSugar.aggregate(MetricTemperature, LastDays, tail(piSugar.lastHourTemperature, minutesInAnHour)), now, config.Days)
+				}
 			}
+		} else {
+			failed = err
 		}
 	}
-	code = piSugar.I2cReadRegister(voltageReg, buf, 2)
-	if code == 0 {
-		lastMinuteVoltage = appendFloat64(lastMinuteVoltage, float64(uint16(buf[0])<<8|uint16(buf[1]))/1000, secondsInAMinute)
-		piSugar.voltage = avgFloat64(lastMinuteVoltage)
-		if counter%60 == 0 {
-			lastHourVoltage = appendFloat64(lastHourVoltage, avgFloat64(lastMinuteVoltage), minutesInAnHour)
-			if counter%1440 == 0 {
-				lastDayVoltage = appendFloat64(lastDayVoltage, avgFloat64(lastHourVoltage), numberOfDays*hoursInADay)
+	if err := piSugar.readRefreshRegister(registers.voltage, buf, 2); err != nil {
+		failed = err
+	} else if voltage := registers.volts(buf); piSugar.isVoltagePlausible(voltage) {
+		piSugar.lastMinuteVoltage = appendSample(piSugar.lastMinuteVoltage, voltage, now, config.Minute)
+		piSugar.voltage = avgSamples(piSugar.lastMinuteVoltage)
+		if piSugar.counter%perMinute == 0 {
+			piSugar.lastHourVoltage = appendSample(piSugar.lastHourVoltage, piSugar.aggregate(MetricVoltage, LastHour, tail(piSugar.lastMinuteVoltage, perMinute)), now, config.Hour)
+			if piSugar.counter%perHour == 0 {
+				piSugar.lastDayVoltage = appendSample(piSugar.lastDayVoltage, piSugar.aggregate(MetricVoltage, LastDays, tail(piSugar.lastHourVoltage, minutesInAnHour)), now, config.Days)
 			}
 		}
 	}
-	code = piSugar.I2cReadRegister(batteryChargeReg, buf, 1)
-	if code == 0 {
-		lastMinuteCharge = appendInt(lastMinuteCharge, int(buf[0]), secondsInAMinute)
-		piSugar.charge = int(avgInt(lastMinuteCharge))
-		if counter%60 == 0 {
-			lastHourCharge = appendFloat64(lastHourCharge, avgInt(lastMinuteCharge), minutesInAnHour)
-			if counter%1440 == 0 {
-				lastDayCharge = appendFloat64(lastDayCharge, avgFloat64(lastHourCharge), numberOfDays*hoursInADay)
+	if charge, err := piSugar.readCharge(buf); err != nil {
+		failed = err
+	} else if !piSugar.isChargeOutlier(charge) {
+		piSugar.lastMinuteCharge = appendSample(piSugar.lastMinuteCharge, charge, now, config.Minute)
+		piSugar.charge = int(avgSamples(piSugar.lastMinuteCharge))
+		piSugar.trackThroughput(avgSamples(piSugar.lastMinuteCharge))
+		if piSugar.counter%perMinute == 0 {
+			piSugar.lastHourCharge = appendSample(piSugar.lastHourCharge, piSugar.aggregate(MetricCharge, LastHour, tail(piSugar.lastMinuteCharge, perMinute)), now, config.Hour)
+			if piSugar.counter%perHour == 0 {
+				piSugar.lastDayCharge = appendSample(piSugar.lastDayCharge, piSugar.aggregate(MetricCharge, LastDays, tail(piSugar.lastHourCharge, minutesInAnHour)), now, config.Days)
 			}
 		}
 	}
-	code = piSugar.I2cReadRegister(powerReg, buf, 1)
-	if code == 0 {
-		piSugar.power = buf[0]&0x80 != 0
+	if err := piSugar.readRefreshRegister(registers.power, buf, 1); err == nil {
+		piSugar.power = buf[0]&registers.powerMask != 0
+		piSugar.charging = piSugar.power && buf[0]&registers.chargingMask != 0
+		piSugar.full = buf[0]&registers.fullMask != 0
+		piSugar.critical = buf[0]&registers.criticalMask != 0
+		switch {
+		case !piSugar.power:
+			piSugar.powerSource = PowerSourceNone
+		case buf[0]&registers.gpioMask != 0:
+			piSugar.powerSource = PowerSourceGPIO
+		default:
+			piSugar.powerSource = PowerSourceUSB
+		}
+	} else {
+		failed = err
+	}
+	if registers.inputVoltage != 0 {
+		if err := piSugar.readRefreshRegister(registers.inputVoltage, buf, 2); err == nil {
+			piSugar.inputVoltage = volts(buf)
+		}
+	}
+	if piSugar.model.Capabilities().CurrentSense {
+		if err := piSugar.readRefreshRegister(chargingCurrentReg, buf, 2); err == nil {
+			piSugar.current = currentFromRegister(buf)
+		}
+	}
+	piSugar.detectEvents()
+	piSugar.checkLowBattery()
+	piSugar.checkPowerChange()
+	piSugar.readButton(buf)
+	piSugar.readFaults(buf)
+	if piSugar.watchdog {
+		if err := piSugar.setRegisterBits(control2Reg, watchdogFeedMask, true); err != nil {
+			Debug("Can't feed watchdog %v", err)
+		}
+	}
+	piSugar.lastError = failed
+	if failed == nil {
+		piSugar.lastRefresh = time.Now()
+		piSugar.notifySystemd()
+	}
+	if debug.Load() {
+		Debug("%v, Vin = %.3fV", piSugar.status(), piSugar.inputVoltage)
 	}
-	Debug("T = %dºC, V = %.3fV, B = %d%%, P = %t",
-		piSugar.temperature,
-		piSugar.voltage,
-		piSugar.charge,
-		piSugar.power)
+	save = piSugar.persist(now)
+	piSugar.publish(piSugar.status())
 }
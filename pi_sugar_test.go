@@ -0,0 +1,185 @@
+/*
+   pi_sugar_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testDevice is a PiSugar 3 register map behind the i2c interface
+type testDevice struct {
+	registers [256]byte
+}
+
+func (device *testDevice) I2cReadRegister(reg uint32, buf []byte, n uint32) int {
+	for i := uint32(0); i < n; i++ {
+		buf[i] = device.registers[byte(reg+i)]
+	}
+	return 0
+}
+
+func (device *testDevice) I2cWrite(data ...byte) int {
+	for i, value := range data[1:] {
+		device.registers[data[0]+byte(i)] = value
+	}
+	return 0
+}
+
+func (device *testDevice) I2cSetSlaveAddress(address uint32) {}
+
+func (device *testDevice) I2cEnd() {}
+
+// newTestPiSugar returns a PiSugar 3 on a testDevice reporting 3.9V, as
+// set up by Init, refreshed every interval
+func newTestPiSugar(interval time.Duration) (*PiSugar, *testDevice) {
+	device := &testDevice{}
+	device.registers[voltageReg], device.registers[voltageReg+1] = 0x0f, 0x3c
+	piSugar := &PiSugar{
+		i2c:               device,
+		model:             ModelPiSugar3,
+		temperatureOffset: DefaultTemperatureOffset,
+		eventLogSize:      DefaultEventLogSize,
+		minVoltage:        DefaultMinVoltage,
+		maxVoltage:        DefaultMaxVoltage,
+		interval:          interval,
+	}
+	piSugar.setHistoryConfig(DefaultHistoryConfig())
+	return piSugar, device
+}
+
+func TestRefreshRollover(t *testing.T) {
+	tests := []struct {
+		name               string
+		interval           time.Duration
+		refreshes          int
+		minute, hour, days int
+	}{
+		{"first refresh", time.Second, 1, 1, 0, 0},
+		{"before the first minute", time.Second, 59, 59, 0, 0},
+		{"first minute", time.Second, 60, 60, 1, 0},
+		{"minute window full", time.Second, 61, 60, 1, 0},
+		{"before the first hour", time.Second, 3599, 60, 59, 0},
+		{"first hour", time.Second, 3600, 60, 60, 1},
+		{"second hour", time.Second, 7200, 60, 60, 2},
+		{"10s before the first minute", 10 * time.Second, 5, 5, 0, 0},
+		{"10s first minute", 10 * time.Second, 6, 6, 1, 0},
+		{"10s first hour", 10 * time.Second, 360, 60, 60, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			piSugar, device := newTestPiSugar(test.interval)
+			device.registers[batteryChargeReg] = 50
+			for i := 0; i < test.refreshes; i++ {
+				piSugar.Refresh()
+			}
+			for window, want := range map[HistoryWindow]int{LastMinute: test.minute, LastHour: test.hour, LastDays: test.days} {
+				if got := len(piSugar.ChargeHistory(window)); got != want {
+					t.Errorf("%v charge samples = %d, want %d", window, got, want)
+				}
+				if got := len(piSugar.VoltageHistory(window)); got != want {
+					t.Errorf("%v voltage samples = %d, want %d", window, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshRolloverAverages(t *testing.T) {
+	piSugar, device := newTestPiSugar(time.Second)
+	for i := 1; i <= 120; i++ {
+		device.registers[batteryChargeReg] = byte(i % 100)
+		piSugar.Refresh()
+	}
+	hour := piSugar.ChargeHistory(LastHour)
+	if len(hour) != 2 {
+		t.Fatalf("hour samples = %d, want 2", len(hour))
+	}
+	// the means of 1..60, then of 61..99 and 0..20
+	for i, want := range []float64{30.5, 55.5} {
+		if diff := hour[i].Value - want; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("hour sample %d = %v, want %v", i, hour[i].Value, want)
+		}
+	}
+	if got, want := piSugar.Charge(), int(avgSamples(piSugar.ChargeHistory(LastMinute))); got != want {
+		t.Errorf("Charge() = %d, want the minute average %d", got, want)
+	}
+}
+
+func TestRefreshCharging(t *testing.T) {
+	tests := []struct {
+		name     string
+		power    byte
+		powered  bool
+		charging bool
+		full     bool
+		state    ChargeState
+		source   PowerSource
+	}{
+		{"on battery", 0, false, false, false, Discharging, PowerSourceNone},
+		{"charging bit without power", powerChargingMask, false, false, false, Discharging, PowerSourceNone},
+		{"charging", powerExternalMask | powerChargingMask, true, true, false, Charging, PowerSourceUSB},
+		// unconfirmed bits, not read on the PiSugar 3
+		{"GPIO bit ignored", powerExternalMask | powerChargingMask | powerGPIOMask, true, true, false, Charging, PowerSourceUSB},
+		{"full bit ignored", powerExternalMask | powerFullMask, true, false, false, Powered, PowerSourceUSB},
+		{"powered, not charging", powerExternalMask, true, false, false, Powered, PowerSourceUSB},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			piSugar, device := newTestPiSugar(time.Second)
+			device.registers[batteryChargeReg] = 50
+			device.registers[powerReg] = test.power
+			piSugar.Refresh()
+			if got := piSugar.Power(); got != test.powered {
+				t.Errorf("Power() = %t, want %t", got, test.powered)
+			}
+			if got := piSugar.Charging(); got != test.charging {
+				t.Errorf("Charging() = %t, want %t", got, test.charging)
+			}
+			if got := piSugar.IsFull(); got != test.full {
+				t.Errorf("IsFull() = %t, want %t", got, test.full)
+			}
+			if got := piSugar.ChargeState(); got != test.state {
+				t.Errorf("ChargeState() = %v, want %v", got, test.state)
+			}
+			if got := piSugar.PowerSource(); got != test.source {
+				t.Errorf("PowerSource() = %v, want %v", got, test.source)
+			}
+		})
+	}
+}
+
+func TestReasonError(t *testing.T) {
+	tests := []struct {
+		code int
+		want error
+	}{
+		{i2cReasonNACK, ErrNACK},
+		{i2cReasonClockStretchTimeout, ErrClockStretchTimeout},
+		{i2cReasonData, ErrShortTransfer},
+		{i2cReasonTimeout, ErrI2cTimeout},
+		{i2cReasonNACK | i2cReasonData, ErrNACK},
+	}
+	for _, test := range tests {
+		if got := reasonError(test.code); !errors.Is(got, test.want) {
+			t.Errorf("reasonError(%#x) = %v, want %v", test.code, got, test.want)
+		}
+	}
+}
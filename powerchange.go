@@ -0,0 +1,55 @@
+/*
+   powerchange,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+// powerChangeReadings is the number of consecutive readings needed to
+// report a power change, so a loose connector doesn't flood the hooks
+const powerChangeReadings = 3
+
+// OnPowerChange calls fn, in its own goroutine, when external power is
+// plugged in (powered is true) or lost, once the new state held for
+// powerChangeReadings refreshes in a row. The state seen by the first
+// refresh isn't reported.
+func (piSugar *PiSugar) OnPowerChange(fn func(powered bool)) {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.powerChangeHooks = append(piSugar.powerChangeHooks, fn)
+}
+
+// checkPowerChange calls the power change hooks when the power state
+// changed for long enough
+func (piSugar *PiSugar) checkPowerChange() {
+	if !piSugar.powerSeen {
+		piSugar.powerDebounced, piSugar.powerSeen = piSugar.power, true
+		return
+	}
+	if piSugar.power == piSugar.powerDebounced {
+		piSugar.powerDiffering = 0
+		return
+	}
+	piSugar.powerDiffering++
+	if piSugar.powerDiffering < powerChangeReadings {
+		return
+	}
+	piSugar.powerDebounced, piSugar.powerDiffering = piSugar.power, 0
+	Debug("External power %v", piSugar.power)
+	for _, fn := range piSugar.powerChangeHooks {
+		go fn(piSugar.power)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+   rate,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import "time"
+
+const (
+	minRateSamples = 2                   // minimum number of samples to fit a rate
+	maxEstimate    = 30 * 24 * time.Hour // longest time estimate, beyond it the slope is flat
+)
+
+// slope returns the least-squares slope of table, in value per hour.
+// ok is false when there are not enough samples spread over time.
+func slope(table []Sample) (perHour float64, ok bool) {
+	if len(table) < minRateSamples {
+		return 0, false
+	}
+	origin := table[0].Time
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range table {
+		x := sample.Time.Sub(origin).Hours()
+		sumX += x
+		sumY += sample.Value
+		sumXY += x * sample.Value
+		sumXX += x * x
+	}
+	n := float64(len(table))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
+
+// ChargeRate returns the charge rate in percent per hour over the last
+// hour, positive when charging and negative when discharging. ok is false
+// until enough history is collected.
+func (piSugar *PiSugar) ChargeRate() (perHour float64, ok bool) {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return slope(piSugar.lastHourCharge)
+}
+
+// VoltageRate returns the battery voltage change in volts per hour over the
+// last hour. ok is false until enough history is collected.
+func (piSugar *PiSugar) VoltageRate() (perHour float64, ok bool) {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return slope(piSugar.lastHourVoltage)
+}
+
+// DischargeRate returns how fast the battery discharges, in percent per
+// hour over the last hour, or 0 when it isn't discharging or there is not
+// enough history yet
+func (piSugar *PiSugar) DischargeRate() float64 {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	if rate, ok := slope(piSugar.lastHourCharge); ok && rate < 0 && !piSugar.power {
+		return -rate
+	}
+	return 0
+}
+
+// EstimatedTimeRemaining returns the time left on battery at the current
+// DischargeRate(), or 0 when it can't be estimated
+func (piSugar *PiSugar) EstimatedTimeRemaining() time.Duration {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.timeRemaining()
+}
+
+// timeRemaining is EstimatedTimeRemaining, the caller holding the mutex
+func (piSugar *PiSugar) timeRemaining() time.Duration {
+	rate, ok := slope(piSugar.lastHourCharge)
+	if !ok || rate >= 0 || piSugar.power {
+		return 0
+	}
+	return hoursToDuration(avgSamples(piSugar.lastMinuteCharge) / -rate)
+}
+
+// EstimatedTimeToFull returns the time left until the battery is full at
+// the current charge rate, or 0 when it isn't charging or it can't be
+// estimated
+func (piSugar *PiSugar) EstimatedTimeToFull() time.Duration {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	rate, ok := slope(piSugar.lastHourCharge)
+	if !ok || rate <= 0 || !piSugar.charging {
+		return 0
+	}
+	return hoursToDuration(max(100-avgSamples(piSugar.lastMinuteCharge), 0) / rate)
+}
+
+// hoursToDuration converts a number of hours to a time.Duration, rounded to
+// the second, or 0 when it is beyond maxEstimate, e.g. from a nearly flat
+// slope that would overflow a time.Duration
+func hoursToDuration(hours float64) time.Duration {
+	if !(hours >= 0 && hours <= maxEstimate.Hours()) {
+		return 0
+	}
+	return time.Duration(hours * float64(time.Hour)).Round(time.Second)
+}
@@ -0,0 +1,115 @@
+/*
+   rate_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// hourly returns samples of values one minute apart
+func hourly(values ...float64) []Sample {
+	origin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := make([]Sample, len(values))
+	for i, value := range values {
+		samples[i] = Sample{Time: origin.Add(time.Duration(i) * time.Minute), Value: value}
+	}
+	return samples
+}
+
+func TestSlope(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []Sample
+		want    float64
+		ok      bool
+	}{
+		{"no samples", nil, 0, false},
+		{"one sample", hourly(50), 0, false},
+		{"same time", []Sample{{Value: 1}, {Value: 2}}, 0, false},
+		{"flat", hourly(50, 50, 50), 0, true},
+		{"discharging 1%/min", hourly(50, 49, 48), -60, true},
+		{"charging 1%/min", hourly(50, 51, 52), 60, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := slope(test.samples)
+			if ok != test.ok || math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("slope = %v, %t, want %v, %t", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestHoursToDuration(t *testing.T) {
+	tests := []struct {
+		hours float64
+		want  time.Duration
+	}{
+		{0, 0},
+		{1.5, 90 * time.Minute},
+		{1.0 / 7200, time.Second},
+		{maxEstimate.Hours(), maxEstimate},
+		{maxEstimate.Hours() + 1, 0},
+		{50 / 6e-8, 0},
+		{-1, 0},
+		{math.Inf(1), 0},
+		{math.NaN(), 0},
+	}
+	for _, test := range tests {
+		if got := hoursToDuration(test.hours); got != test.want {
+			t.Errorf("hoursToDuration(%v) = %v, want %v", test.hours, got, test.want)
+		}
+	}
+}
+
+func TestTimeRemaining(t *testing.T) {
+	tests := []struct {
+		name     string
+		hour     []Sample
+		power    bool
+		charging bool
+		left     time.Duration
+		full     time.Duration
+	}{
+		{"discharging", hourly(51, 50.5, 50), false, false, 100 * time.Minute, 0},
+		{"nearly flat", hourly(50, 50-1e-9, 50-2e-9), false, false, 0, 0},
+		{"flat", hourly(50, 50, 50), false, false, 0, 0},
+		{"powered", hourly(51, 50.5, 50), true, false, 0, 0},
+		{"charging", hourly(49, 49.5, 50), true, true, 0, 100 * time.Minute},
+		{"charging nearly flat", hourly(50, 50+1e-9, 50+2e-9), true, true, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			piSugar := &PiSugar{
+				lastHourCharge:   test.hour,
+				lastMinuteCharge: hourly(50),
+				power:            test.power,
+				charging:         test.charging,
+			}
+			if got := piSugar.EstimatedTimeRemaining(); got != test.left {
+				t.Errorf("EstimatedTimeRemaining() = %v, want %v", got, test.left)
+			}
+			if got := piSugar.EstimatedTimeToFull(); got != test.full {
+				t.Errorf("EstimatedTimeToFull() = %v, want %v", got, test.full)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+/*
+   registerfile,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"fmt"
+	"io"
+)
+
+// registerFile is an io.ReadWriteSeeker over the registers of the PiSugar
+type registerFile struct {
+	piSugar *PiSugar
+	offset  int64 // next register
+}
+
+// RegisterFile returns an io.ReadWriteSeeker over the register space of the
+// board, starting at register reg: Seek moves to a register, and Read and
+// Write go on from there, Read returning io.EOF at the end of the register
+// space and Write io.ErrShortWrite.
+// It goes through ReadRegister and WriteRegister, with the same warnings.
+func (piSugar *PiSugar) RegisterFile(reg uint8) io.ReadWriteSeeker {
+	return &registerFile{piSugar: piSugar, offset: int64(reg)}
+}
+
+// Read reads the registers from the current one on, up to len(p)
+func (file *registerFile) Read(p []byte) (int, error) {
+	if file.offset >= registerCount {
+		return 0, io.EOF
+	}
+	n := min(int64(len(p)), registerCount-file.offset)
+	data, err := file.piSugar.ReadRegister(byte(file.offset), int(n))
+	if err != nil {
+		return 0, err
+	}
+	file.offset += n
+	return copy(p, data), nil
+}
+
+// Write writes p to the registers from the current one on, returning
+// io.ErrShortWrite if it doesn't fit before the end of the register space
+func (file *registerFile) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n := max(min(int64(len(p)), registerCount-file.offset), 0)
+	if n > 0 {
+		if err := file.piSugar.WriteRegister(byte(file.offset), p[:n]); err != nil {
+			return 0, err
+		}
+		file.offset += n
+	}
+	if int(n) < len(p) {
+		return int(n), io.ErrShortWrite
+	}
+	return int(n), nil
+}
+
+// Seek sets the current register, like (*os.File).Seek
+func (file *registerFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += file.offset
+	case io.SeekEnd:
+		offset += registerCount
+	default:
+		return file.offset, fmt.Errorf("%w: whence %d", ErrOutOfRange, whence)
+	}
+	if offset < 0 {
+		return file.offset, fmt.Errorf("%w: register %d", ErrOutOfRange, offset)
+	}
+	file.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,117 @@
+/*
+   registerfile_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRegisterFileSeek(t *testing.T) {
+	piSugar, _ := newTestPiSugar(time.Second)
+	file := piSugar.RegisterFile(0x10)
+	tests := []struct {
+		offset int64
+		whence int
+		want   int64
+		err    error
+	}{
+		{0, io.SeekCurrent, 0x10, nil},
+		{4, io.SeekCurrent, 0x14, nil},
+		{0x20, io.SeekStart, 0x20, nil},
+		{-1, io.SeekEnd, 0xff, nil},
+		{1, io.SeekEnd, 0x101, nil},
+		{-1, io.SeekStart, 0x101, ErrOutOfRange},
+		{0, 3, 0x101, ErrOutOfRange},
+	}
+	for _, test := range tests {
+		got, err := file.Seek(test.offset, test.whence)
+		if got != test.want || !errors.Is(err, test.err) {
+			t.Errorf("Seek(%d, %d) = %#x, %v, want %#x, %v", test.offset, test.whence, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestRegisterFileRead(t *testing.T) {
+	piSugar, device := newTestPiSugar(time.Second)
+	device.registers[0xfe], device.registers[0xff] = 1, 2
+	tests := []struct {
+		name  string
+		start int64
+		size  int
+		want  []byte
+		err   error
+	}{
+		{"inside", 0x22, 2, []byte{0x0f, 0x3c}, nil},
+		{"up to the end", 0xfe, 2, []byte{1, 2}, nil},
+		{"past the end", 0xfe, 4, []byte{1, 2}, nil},
+		{"at the end", 0x100, 1, []byte{}, io.EOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := piSugar.RegisterFile(0)
+			if _, err := file.Seek(test.start, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, test.size)
+			n, err := file.Read(buf)
+			if !bytes.Equal(buf[:n], test.want) || !errors.Is(err, test.err) {
+				t.Errorf("Read = % x, %v, want % x, %v", buf[:n], err, test.want, test.err)
+			}
+			if offset, _ := file.Seek(0, io.SeekCurrent); offset != test.start+int64(n) {
+				t.Errorf("offset after Read = %#x, want %#x", offset, test.start+int64(n))
+			}
+		})
+	}
+}
+
+func TestRegisterFileWrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		start int64
+		data  []byte
+		n     int
+		err   error
+	}{
+		{"inside", 0x40, []byte{1, 2}, 2, nil},
+		{"up to the end", 0xfe, []byte{1, 2}, 2, nil},
+		{"past the end", 0xfe, []byte{1, 2, 3}, 2, io.ErrShortWrite},
+		{"at the end", 0x100, []byte{1}, 0, io.ErrShortWrite},
+		{"nothing at the end", 0x100, nil, 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			piSugar, device := newTestPiSugar(time.Second)
+			file := piSugar.RegisterFile(0)
+			if _, err := file.Seek(test.start, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			n, err := file.Write(test.data)
+			if n != test.n || !errors.Is(err, test.err) {
+				t.Errorf("Write = %d, %v, want %d, %v", n, err, test.n, test.err)
+			}
+			if n > 0 && !bytes.Equal(device.registers[test.start:test.start+int64(n)], test.data[:n]) {
+				t.Errorf("registers = % x, want % x", device.registers[test.start:test.start+int64(n)], test.data[:n])
+			}
+		})
+	}
+}
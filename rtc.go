@@ -0,0 +1,177 @@
+/*
+   rtc,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// The RTC registers hold, in BCD: seconds, minutes, hours (24h), weekday
+// (0 is Sunday), day, month and year since 2000, in UTC. On the PiSugar 3
+// they follow the other registers; on the PiSugar 2 they are those of an
+// SD3078 chip with its own address, write protected, as in its datasheet.
+// The PiSugar 3 layout is unconfirmed (see Capabilities).
+const (
+	rtcReg           = 0x31 // PiSugar 3
+	rtcLength        = 7
+	sd3078I2CAddress = 0x32
+	sd3078RTCReg     = 0x00
+	sd3078HourMask   = 0x80 // in the hours register, set for 24h format
+	sd3078Ctr1Reg    = 0x0f // WRTC2 and WRTC3 write enable bits
+	sd3078Ctr2Reg    = 0x10 // WRTC1 write enable bit
+	sd3078Ctr1Unlock = 0x84
+	sd3078Ctr2Unlock = 0x80
+)
+
+// rtcLocation returns the address and first register of the RTC of the model
+func (model Model) rtcLocation() (address uint32, reg byte) {
+	if model.isPiSugar3() {
+		return piSugarI2CAddress, rtcReg
+	}
+	return sd3078I2CAddress, sd3078RTCReg
+}
+
+// withAddress runs do with the device addressing address, then addresses
+// the board again
+func (piSugar *PiSugar) withAddress(address uint32, do func() error) error {
+	board := piSugar.model.registers().address
+	if address == board {
+		return do()
+	}
+	piSugar.I2cSetSlaveAddress(address)
+	defer piSugar.I2cSetSlaveAddress(board)
+	return do()
+}
+
+// ReadRTC reads the time kept by the RTC of the board. It returns
+// ErrUnsupported on boards without one, and ErrOutOfRange if it was never
+// set.
+func (piSugar *PiSugar) ReadRTC() (time.Time, error) {
+	var buf = make([]byte, rtcLength)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().RTCAlarm {
+		return time.Time{}, ErrUnsupported
+	}
+	address, reg := piSugar.model.rtcLocation()
+	if err := piSugar.withAddress(address, func() error {
+		return piSugar.readRegister(reg, buf, rtcLength)
+	}); err != nil {
+		return time.Time{}, err
+	}
+	buf[2] &^= sd3078HourMask
+	return rtcTime(buf)
+}
+
+// WriteRTC sets the RTC of the board to t, to the second. It returns
+// ErrUnsupported on boards without one.
+func (piSugar *PiSugar) WriteRTC(t time.Time) error {
+	t = t.UTC()
+	if t.Year() < 2000 || t.Year() > 2099 {
+		return fmt.Errorf("%w: RTC year %d", ErrOutOfRange, t.Year())
+	}
+	data := []byte{
+		toBCD(t.Second()),
+		toBCD(t.Minute()),
+		toBCD(t.Hour()),
+		toBCD(int(t.Weekday())),
+		toBCD(t.Day()),
+		toBCD(int(t.Month())),
+		toBCD(t.Year() - 2000),
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().RTCAlarm {
+		return ErrUnsupported
+	}
+	address, reg := piSugar.model.rtcLocation()
+	if piSugar.model.isPiSugar3() {
+		return piSugar.writeRegister(reg, data...)
+	}
+	data[2] |= sd3078HourMask
+	return piSugar.withAddress(address, func() error {
+		return piSugar.sd3078Unlocked(func() error {
+			return piSugar.writeRegister(reg, data...)
+		})
+	})
+}
+
+// sd3078Unlocked runs do with the write protection of the SD3078 lifted,
+// the device addressing it
+func (piSugar *PiSugar) sd3078Unlocked(do func() error) error {
+	if err := piSugar.setRegisterBits(sd3078Ctr2Reg, sd3078Ctr2Unlock, true); err != nil {
+		return err
+	}
+	if err := piSugar.setRegisterBits(sd3078Ctr1Reg, sd3078Ctr1Unlock, true); err != nil {
+		return err
+	}
+	err := do()
+	if lockErr := piSugar.setRegisterBits(sd3078Ctr1Reg, sd3078Ctr1Unlock, false); err == nil {
+		err = lockErr
+	}
+	if lockErr := piSugar.setRegisterBits(sd3078Ctr2Reg, sd3078Ctr2Unlock, false); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+// SyncRTCFromSystem sets the RTC of the board to the system time, e.g. once
+// NTP synchronized it
+func (piSugar *PiSugar) SyncRTCFromSystem() error {
+	return piSugar.WriteRTC(time.Now())
+}
+
+// SyncSystemFromRTC sets the system time from the RTC of the board, e.g. at
+// boot on a Pi without network. It needs to run as root.
+func (piSugar *PiSugar) SyncSystemFromRTC() error {
+	t, err := piSugar.ReadRTC()
+	if err != nil {
+		return err
+	}
+	tv := syscall.NsecToTimeval(t.UnixNano())
+	return syscall.Settimeofday(&tv)
+}
+
+// rtcTime decodes the RTC registers in buf
+func rtcTime(buf []byte) (time.Time, error) {
+	var values [rtcLength]int
+	for i := range values {
+		if buf[i]&0x0f > 9 || buf[i]>>4 > 9 {
+			return time.Time{}, fmt.Errorf("%w: RTC register %d is 0x%02x, not BCD", ErrOutOfRange, i, buf[i])
+		}
+		values[i] = fromBCD(buf[i])
+	}
+	second, minute, hour, day, month, year := values[0], values[1], values[2], values[4], values[5], values[6]
+	if second > 59 || minute > 59 || hour > 23 || day < 1 || day > 31 || month < 1 || month > 12 {
+		return time.Time{}, fmt.Errorf("%w: RTC not set", ErrOutOfRange)
+	}
+	return time.Date(2000+year, time.Month(month), day, hour, minute, second, 0, time.UTC), nil
+}
+
+// toBCD encodes value (0-99) in BCD
+func toBCD(value int) byte {
+	return byte(value/10<<4 | value%10)
+}
+
+// fromBCD decodes the BCD value
+func fromBCD(value byte) int {
+	return int(value>>4)*10 + int(value&0x0f)
+}
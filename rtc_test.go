@@ -0,0 +1,71 @@
+/*
+   rtc_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBCD(t *testing.T) {
+	tests := []struct {
+		value int
+		bcd   byte
+	}{
+		{0, 0x00},
+		{9, 0x09},
+		{10, 0x10},
+		{42, 0x42},
+		{99, 0x99},
+	}
+	for _, test := range tests {
+		if got := toBCD(test.value); got != test.bcd {
+			t.Errorf("toBCD(%d) = %#02x, want %#02x", test.value, got, test.bcd)
+		}
+		if got := fromBCD(test.bcd); got != test.value {
+			t.Errorf("fromBCD(%#02x) = %d, want %d", test.bcd, got, test.value)
+		}
+	}
+}
+
+func TestRTCTime(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte // seconds, minutes, hours, weekday, day, month, year
+		want time.Time
+		err  error
+	}{
+		{"set", []byte{0x56, 0x34, 0x12, 0x01, 0x15, 0x07, 0x24}, time.Date(2024, 7, 15, 12, 34, 56, 0, time.UTC), nil},
+		{"first", []byte{0x00, 0x00, 0x00, 0x06, 0x01, 0x01, 0x00}, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"last", []byte{0x59, 0x59, 0x23, 0x05, 0x31, 0x12, 0x99}, time.Date(2099, 12, 31, 23, 59, 59, 0, time.UTC), nil},
+		{"not BCD", []byte{0x5a, 0x34, 0x12, 0x01, 0x15, 0x07, 0x24}, time.Time{}, ErrOutOfRange},
+		{"not set", []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, time.Time{}, ErrOutOfRange},
+		{"bad hour", []byte{0x00, 0x00, 0x24, 0x01, 0x15, 0x07, 0x24}, time.Time{}, ErrOutOfRange},
+		{"bad month", []byte{0x00, 0x00, 0x12, 0x01, 0x15, 0x13, 0x24}, time.Time{}, ErrOutOfRange},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := rtcTime(test.buf)
+			if !got.Equal(test.want) || !errors.Is(err, test.err) {
+				t.Errorf("rtcTime(% x) = %v, %v, want %v, %v", test.buf, got, err, test.want, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+/*
+   selftest,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import "fmt"
+
+// plausible ranges checked by SelfTest
+const (
+	minPlausibleVoltage     = 2.5
+	maxPlausibleVoltage     = 4.5
+	minPlausibleTemperature = -20
+	maxPlausibleTemperature = 80
+)
+
+// Check is the result of one SelfTest step
+type Check struct {
+	Name   string
+	Passed bool
+	Detail string
+}
+
+// Report lists the checks run by SelfTest
+type Report struct {
+	Checks []Check
+}
+
+// Passed tells whether all checks passed
+func (report Report) Passed() bool {
+	for _, check := range report.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func (report *Report) add(name string, passed bool, format string, args ...interface{}) {
+	report.Checks = append(report.Checks, Check{Name: name, Passed: passed, Detail: fmt.Sprintf(format, args...)})
+}
+
+// SelfTest reads the board directly and checks it answers with plausible
+// values. The error is only set when the board doesn't answer at all.
+func (piSugar *PiSugar) SelfTest() (report Report, err error) {
+	var buf = make([]byte, 2)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	registers := piSugar.model.registers()
+
+	if err = piSugar.readRegister(registers.power, buf, 1); err != nil {
+		report.add("probe", false, "%v", err)
+		return report, fmt.Errorf("%w: %w", ErrNoDevice, err)
+	}
+	report.add("probe", true, "%v answering at 0x%02x", piSugar.model, registers.address)
+
+	if piSugar.model.Capabilities().Firmware {
+		if err := piSugar.readRegister(firmwareReg, buf, 2); err != nil {
+			report.add("firmware", false, "%v", err)
+		} else {
+			report.add("firmware", true, "version %d.%d", buf[0], buf[1])
+		}
+	}
+
+	voltage, voltageErr := 0.0, piSugar.readRegister(registers.voltage, buf, 2)
+	if voltageErr != nil {
+		report.add("voltage", false, "%v", voltageErr)
+	} else {
+		voltage = registers.volts(buf)
+		report.add("voltage", voltage >= minPlausibleVoltage && voltage <= maxPlausibleVoltage, "%.3fV", voltage)
+	}
+
+	// without a fuel gauge, the charge comes from the voltage just read,
+	// not from the history readCharge uses
+	var charge float64
+	var chargeErr error
+	if piSugar.model.Capabilities().FuelGauge {
+		charge, chargeErr = piSugar.readCharge(buf)
+	} else if chargeErr = voltageErr; chargeErr == nil {
+		charge = curvePercent(piSugar.batteryCurve, voltage)
+	}
+	if chargeErr != nil {
+		report.add("charge", false, "%v", chargeErr)
+	} else {
+		report.add("charge", charge >= 0 && charge <= 100, "%.1f%%", charge)
+	}
+
+	if registers.temperature == 0 {
+		return report, nil
+	}
+	if err := piSugar.readRegister(registers.temperature, buf, 1); err != nil {
+		report.add("temperature", false, "%v", err)
+	} else {
+		temperature := piSugar.convertTemperature(buf[0])
+		report.add("temperature", temperature >= minPlausibleTemperature && temperature <= maxPlausibleTemperature, "%d°C", temperature)
+	}
+	return report, nil
+}
@@ -0,0 +1,47 @@
+/*
+   selftest_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSelfTestPiSugar2Charge(t *testing.T) {
+	piSugar, device := newTestPiSugar(time.Second)
+	piSugar.model = ModelPiSugar2
+	// 3.9V, 4841 steps of 0.26855mV above 2.6V
+	device.registers[ip5209VoltageReg], device.registers[ip5209VoltageReg+1] = 0xe9, 0x12
+	report, err := piSugar.SelfTest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := curvePercent(nil, ip5xxxVolts([]byte{0xe9, 0x12}))
+	for _, check := range report.Checks {
+		if check.Name != "charge" {
+			continue
+		}
+		if !check.Passed || check.Detail != fmt.Sprintf("%.1f%%", want) {
+			t.Errorf("charge check = %+v, want passed at %.1f%%", check, want)
+		}
+		return
+	}
+	t.Errorf("no charge check in %+v", report.Checks)
+}
@@ -0,0 +1,120 @@
+/*
+   shutdown,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"fmt"
+	"time"
+)
+
+// PowerControl registers, unconfirmed (see Capabilities)
+const (
+	powerOffDelayReg = 0x09 // seconds before the board cuts power, written to request it
+	maxPowerOffDelay = 255 * time.Second
+	outputEnableMask = 0x40 // in control2Reg, 5V output to the Pi
+)
+
+// SetAutoShutdownPercent programs the firmware to cut power to the Pi when
+// the battery drops below percent (0 disables it). This works even if the
+// OS has hung, so it complements any software shutdown logic. It returns
+// ErrUnsupported on models without AutoShutdown.
+func (piSugar *PiSugar) SetAutoShutdownPercent(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("%w: auto shutdown at %d%%", ErrOutOfRange, percent)
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().AutoShutdown {
+		return ErrUnsupported
+	}
+	return piSugar.writeRegister(autoShutdownReg, byte(percent))
+}
+
+// AutoShutdownPercent returns the battery percentage below which the
+// firmware cuts power (0 when disabled). It returns ErrUnsupported on
+// models without AutoShutdown.
+func (piSugar *PiSugar) AutoShutdownPercent() (int, error) {
+	var buf = make([]byte, 1)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().AutoShutdown {
+		return 0, ErrUnsupported
+	}
+	if err := piSugar.readRegister(autoShutdownReg, buf, 1); err != nil {
+		return 0, err
+	}
+	return int(buf[0]), nil
+}
+
+// RequestPowerOff tells the firmware to cut power to the Pi after delay
+// (rounded up to the second, at most 255s), e.g. once the OS had time to
+// halt after `shutdown -h now`. It returns ErrUnsupported on boards
+// without PowerControl.
+func (piSugar *PiSugar) RequestPowerOff(delay time.Duration) error {
+	seconds, err := powerOffSeconds(delay)
+	if err != nil {
+		return err
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().PowerControl {
+		return ErrUnsupported
+	}
+	return piSugar.writeRegister(powerOffDelayReg, seconds)
+}
+
+// PowerOff switches the 5V output off after delay, as RequestPowerOff,
+// and also clears the output switch so the Pi stays off until the button
+// is pressed or the output is enabled again.
+func (piSugar *PiSugar) PowerOff(delay time.Duration) error {
+	seconds, err := powerOffSeconds(delay)
+	if err != nil {
+		return err
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().PowerControl {
+		return ErrUnsupported
+	}
+	return piSugar.writeEnabled(func() error {
+		if err := piSugar.writeRegister(powerOffDelayReg, seconds); err != nil {
+			return err
+		}
+		return piSugar.setRegisterBits(control2Reg, outputEnableMask, false)
+	})
+}
+
+// SetOutputEnabled switches the 5V output to the Pi on or off, immediately.
+// It returns ErrUnsupported on boards without PowerControl.
+func (piSugar *PiSugar) SetOutputEnabled(enabled bool) error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().PowerControl {
+		return ErrUnsupported
+	}
+	return piSugar.setRegisterBits(control2Reg, outputEnableMask, enabled)
+}
+
+// powerOffSeconds converts delay to the value of powerOffDelayReg
+func powerOffSeconds(delay time.Duration) (byte, error) {
+	if delay < 0 || delay > maxPowerOffDelay {
+		return 0, fmt.Errorf("%w: power off delay %v", ErrOutOfRange, delay)
+	}
+	return byte((delay + time.Second - 1) / time.Second), nil
+}
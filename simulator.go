@@ -0,0 +1,177 @@
+/*
+   simulator,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"sort"
+	"time"
+)
+
+// PowerChange switches the simulated external power on or off, After the
+// simulation started
+type PowerChange struct {
+	After time.Duration
+	Power bool
+}
+
+// Scenario drives a simulated PiSugar
+type Scenario struct {
+	Charge        float64       // initial charge, in percent
+	DischargeRate float64       // percent lost per hour on battery
+	ChargeRate    float64       // percent gained per hour on external power
+	Temperature   int           // board temperature in °C
+	Curve         []CurvePoint  // voltage for a given charge, default LiPo curve if empty
+	Power         bool          // initial external power
+	PowerChanges  []PowerChange // scripted power events
+	Step          time.Duration // simulated time per Refresh(), 1s if zero
+}
+
+// simulator is a fake PiSugar 3 whose registers follow a Scenario, one step
+// per Refresh()
+type simulator struct {
+	scenario  Scenario
+	registers [256]byte
+	elapsed   time.Duration
+	charge    float64
+	power     bool
+}
+
+// InitSimulated replaces the hardware with a simulation of scenario, so the
+// package can be used off-device, e.g. to build dashboards on a laptop.
+// Each Refresh() advances the simulation by scenario.Step. As Init, it
+// only returns the previous result until End() is called.
+func InitSimulated(scenario Scenario) error {
+	initMutex.Lock()
+	defer initMutex.Unlock()
+	if initDone {
+		return initErr
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if scenario.Step <= 0 {
+		scenario.Step = time.Second
+	}
+	curve := append([]CurvePoint{}, scenario.Curve...)
+	sort.Slice(curve, func(i, j int) bool {
+		return curve[i].Voltage < curve[j].Voltage
+	})
+	scenario.Curve = curve
+	scenario.PowerChanges = append([]PowerChange{}, scenario.PowerChanges...)
+	sort.Slice(scenario.PowerChanges, func(i, j int) bool {
+		return scenario.PowerChanges[i].After < scenario.PowerChanges[j].After
+	})
+	sim := &simulator{
+		scenario: scenario,
+		charge:   min(max(scenario.Charge, 0), 100),
+		power:    scenario.Power,
+	}
+	sim.registers[firmwareReg] = 3
+	sim.registers[modelIDReg] = 3
+	sim.update()
+	piSugar.i2c = sim
+	piSugar.bus = noBus
+	piSugar.model = ModelPiSugar3
+	initDone, initErr = true, nil
+	initialized = true
+	return nil
+}
+
+// tick advances the simulation by one step
+func (sim *simulator) tick() {
+	step := sim.scenario.Step
+	for _, change := range sim.scenario.PowerChanges {
+		if change.After > sim.elapsed && change.After <= sim.elapsed+step {
+			sim.power = change.Power
+		}
+	}
+	sim.elapsed += step
+	if sim.power {
+		sim.charge += sim.scenario.ChargeRate * step.Hours()
+	} else {
+		sim.charge -= sim.scenario.DischargeRate * step.Hours()
+	}
+	sim.charge = min(max(sim.charge, 0), 100)
+	sim.update()
+}
+
+// update sets the registers from the simulated state
+func (sim *simulator) update() {
+	var power byte
+	if sim.power {
+		power |= powerExternalMask
+		if sim.charge < 100 {
+			power |= powerChargingMask
+		}
+	}
+	if sim.charge >= 100 {
+		power |= powerFullMask
+	}
+	if sim.charge < 1 {
+		power |= powerCriticalMask
+	}
+	sim.registers[powerReg] = power
+	sim.registers[temperatureReg] = byte(sim.scenario.Temperature - DefaultTemperatureOffset)
+	millivolts := uint16(curveVoltage(sim.scenario.Curve, sim.charge) * 1000)
+	sim.registers[voltageReg], sim.registers[voltageReg+1] = byte(millivolts>>8), byte(millivolts)
+	var inputMillivolts uint16
+	if sim.power {
+		inputMillivolts = 5000
+	}
+	sim.registers[inputVoltageReg], sim.registers[inputVoltageReg+1] = byte(inputMillivolts>>8), byte(inputMillivolts)
+	sim.registers[batteryChargeReg] = byte(sim.charge)
+}
+
+func (sim *simulator) I2cReadRegister(regAddr uint32, buf []byte, len uint32) int {
+	for i := uint32(0); i < len; i++ {
+		buf[i] = sim.registers[byte(regAddr+i)]
+	}
+	return 0
+}
+
+func (sim *simulator) I2cWrite(data ...byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	for i, value := range data[1:] {
+		sim.registers[data[0]+byte(i)] = value
+	}
+	return 0
+}
+
+func (sim *simulator) I2cSetSlaveAddress(address uint32) {}
+
+func (sim *simulator) I2cEnd() {}
+
+// curveVoltage is the inverse of curvePercent: it interpolates the voltage
+// giving percent on a curve sorted by voltage
+func curveVoltage(curve []CurvePoint, percent float64) float64 {
+	if len(curve) == 0 {
+		curve = defaultBatteryCurve
+	}
+	if percent <= curve[0].Percent {
+		return curve[0].Voltage
+	}
+	for i := 1; i < len(curve); i++ {
+		if percent <= curve[i].Percent {
+			low, high := curve[i-1], curve[i]
+			return low.Voltage + (percent-low.Percent)/(high.Percent-low.Percent)*(high.Voltage-low.Voltage)
+		}
+	}
+	return curve[len(curve)-1].Voltage
+}
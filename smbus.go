@@ -0,0 +1,98 @@
+/*
+   smbus,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"fmt"
+
+	"github.com/peergum/go-rpio/v5"
+)
+
+// smbusBlockMax is the largest SMBus block, not counting its length byte
+const smbusBlockMax = 32
+
+// SMBus adds the SMBus register transfers to an I2C device, for other
+// devices on the bus than the PiSugar. Words are little-endian, as SMBus
+// defines them. Reads use a repeated start between the register and the
+// data. There are no retries and no locking: the caller owns the device.
+type SMBus struct {
+	device i2c
+}
+
+// NewSMBus returns the SMBus transfers of device
+func NewSMBus(device *rpio.I2cDevice) *SMBus {
+	return &SMBus{device: device}
+}
+
+// ReadByteData reads the byte of register reg
+func (bus *SMBus) ReadByteData(reg byte) (byte, error) {
+	var buf = make([]byte, 1)
+	if err := bus.read(reg, buf); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// WriteByteData writes value to register reg
+func (bus *SMBus) WriteByteData(reg byte, value byte) error {
+	return bus.write(reg, value)
+}
+
+// ReadWordData reads the word starting at register reg
+func (bus *SMBus) ReadWordData(reg byte) (uint16, error) {
+	var buf = make([]byte, 2)
+	if err := bus.read(reg, buf); err != nil {
+		return 0, err
+	}
+	return uint16(buf[0]) | uint16(buf[1])<<8, nil
+}
+
+// WriteWordData writes value to the word starting at register reg
+func (bus *SMBus) WriteWordData(reg byte, value uint16) error {
+	return bus.write(reg, byte(value), byte(value>>8))
+}
+
+// ReadBlockData reads the block of register reg, whose first byte is its
+// length (at most 32)
+func (bus *SMBus) ReadBlockData(reg byte) ([]byte, error) {
+	var buf = make([]byte, smbusBlockMax+1)
+	if err := bus.read(reg, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] > smbusBlockMax {
+		return nil, fmt.Errorf("%w: SMBus block of %d bytes", ErrOutOfRange, buf[0])
+	}
+	return buf[1 : 1+buf[0]], nil
+}
+
+// read fills buf from register reg on
+func (bus *SMBus) read(reg byte, buf []byte) error {
+	if code := bus.device.I2cReadRegister(uint32(reg), buf, uint32(len(buf))); code != 0 {
+		return fmt.Errorf("reading register 0x%02x failed: %w", reg, reasonError(code))
+	}
+	return nil
+}
+
+// write writes data to register reg on
+func (bus *SMBus) write(reg byte, data ...byte) error {
+	if code := bus.device.I2cWrite(append([]byte{reg}, data...)...); code != 0 {
+		return fmt.Errorf("writing register 0x%02x failed: %w", reg, reasonError(code))
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+/*
+   state,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const stateVersion = 2 // 2: timestamped samples
+
+// savedState is the JSON layout written by SaveState
+type savedState struct {
+	Version           int      `json:"version"`
+	Counter           int      `json:"counter"`
+	MinuteCharge      []Sample `json:"minuteCharge"`
+	HourCharge        []Sample `json:"hourCharge"`
+	DayCharge         []Sample `json:"dayCharge"`
+	MinuteVoltage     []Sample `json:"minuteVoltage"`
+	HourVoltage       []Sample `json:"hourVoltage"`
+	DayVoltage        []Sample `json:"dayVoltage"`
+	MinuteTemperature []Sample `json:"minuteTemperature"`
+	HourTemperature   []Sample `json:"hourTemperature"`
+	DayTemperature    []Sample `json:"dayTemperature"`
+	ChargeIn          float64  `json:"chargeIn"`
+	ChargeOut         float64  `json:"chargeOut"`
+}
+
+// SaveState writes the history, counters and charge throughput as JSON,
+// to be restored by LoadState after a restart
+func (piSugar *PiSugar) SaveState(w io.Writer) error {
+	piSugar.mutex.RLock()
+	data, err := json.Marshal(piSugar.savedState())
+	piSugar.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// savedState returns the state written by SaveState
+func (piSugar *PiSugar) savedState() savedState {
+	return savedState{
+		Version:           stateVersion,
+		Counter:           piSugar.counter,
+		MinuteCharge:      piSugar.lastMinuteCharge,
+		HourCharge:        piSugar.lastHourCharge,
+		DayCharge:         piSugar.lastDayCharge,
+		MinuteVoltage:     piSugar.lastMinuteVoltage,
+		HourVoltage:       piSugar.lastHourVoltage,
+		DayVoltage:        piSugar.lastDayVoltage,
+		MinuteTemperature: piSugar.lastMinuteTemperature,
+		HourTemperature:   piSugar.lastHourTemperature,
+		DayTemperature:    piSugar.lastDayTemperature,
+		ChargeIn:          piSugar.chargeIn,
+		ChargeOut:         piSugar.chargeOut,
+	}
+}
+
+// clone returns a copy of state not sharing the history of the PiSugar
+func (state savedState) clone() savedState {
+	for _, series := range []*[]Sample{
+		&state.MinuteCharge, &state.HourCharge, &state.DayCharge,
+		&state.MinuteVoltage, &state.HourVoltage, &state.DayVoltage,
+		&state.MinuteTemperature, &state.HourTemperature, &state.DayTemperature,
+	} {
+		*series = append([]Sample(nil), *series...)
+	}
+	return state
+}
+
+// LoadState restores what SaveState wrote. Windows larger than the current
+// HistoryConfig are truncated, keeping the most recent values. A state
+// whose samples aren't in time order is refused with ErrInvalidState,
+// leaving the current one untouched. A refresh counter lower than the
+// number of samples it produced is reset, which only shifts the roll-ups.
+func (piSugar *PiSugar) LoadState(r io.Reader) error {
+	var state savedState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	if state.Version != stateVersion {
+		return fmt.Errorf("unsupported state version %d", state.Version)
+	}
+	if err := state.check(); err != nil {
+		return err
+	}
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	config := piSugar.historyConfig
+	piSugar.counter = state.Counter
+	piSugar.lastMinuteCharge = lastSamples(state.MinuteCharge, config.Minute)
+	piSugar.lastHourCharge = lastSamples(state.HourCharge, config.Hour)
+	piSugar.lastDayCharge = lastSamples(state.DayCharge, config.Days)
+	piSugar.lastMinuteVoltage = lastSamples(state.MinuteVoltage, config.Minute)
+	piSugar.lastHourVoltage = lastSamples(state.HourVoltage, config.Hour)
+	piSugar.lastDayVoltage = lastSamples(state.DayVoltage, config.Days)
+	piSugar.lastMinuteTemperature = lastSamples(state.MinuteTemperature, config.Minute)
+	piSugar.lastHourTemperature = lastSamples(state.HourTemperature, config.Hour)
+	piSugar.lastDayTemperature = lastSamples(state.DayTemperature, config.Days)
+	piSugar.chargeIn = state.ChargeIn
+	piSugar.chargeOut = state.ChargeOut
+	return nil
+}
+
+// ErrInvalidState is returned by LoadState for an inconsistent state
+var ErrInvalidState = errors.New("invalid saved state")
+
+// check returns ErrInvalidState if a history isn't in time order, and
+// resets the counter if fewer refreshes were counted than minute samples
+// were taken
+func (state *savedState) check() error {
+	for name, table := range map[string][]Sample{
+		"minuteCharge":      state.MinuteCharge,
+		"hourCharge":        state.HourCharge,
+		"dayCharge":         state.DayCharge,
+		"minuteVoltage":     state.MinuteVoltage,
+		"hourVoltage":       state.HourVoltage,
+		"dayVoltage":        state.DayVoltage,
+		"minuteTemperature": state.MinuteTemperature,
+		"hourTemperature":   state.HourTemperature,
+		"dayTemperature":    state.DayTemperature,
+	} {
+		for i := 1; i < len(table); i++ {
+			if table[i].Time.Before(table[i-1].Time) {
+				return fmt.Errorf("%w: %s sample %d out of order", ErrInvalidState, name, i)
+			}
+		}
+	}
+	samples := max(len(state.MinuteCharge), len(state.MinuteVoltage), len(state.MinuteTemperature))
+	if state.Counter < samples {
+		warn("Saved refresh counter %d lower than its %d samples, reset", state.Counter, samples)
+		state.Counter = 0
+	}
+	return nil
+}
+
+// lastSamples returns a copy of the last size samples of table
+func lastSamples(table []Sample, size int) []Sample {
+	first := max(len(table)-size, 0)
+	return append(make([]Sample, 0, size), table[first:]...)
+}
@@ -0,0 +1,143 @@
+/*
+   state_test,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// filledPiSugar returns a PiSugar with config whose history was filled by
+// refreshes, charge rising by one each time
+func filledPiSugar(t *testing.T, config HistoryConfig, refreshes int) *PiSugar {
+	piSugar, device := newTestPiSugar(time.Second)
+	piSugar.setHistoryConfig(config)
+	for i := 0; i < refreshes; i++ {
+		device.registers[batteryChargeReg] = byte(i % 100)
+		piSugar.Refresh()
+	}
+	return piSugar
+}
+
+// sameSamples tells whether a and b hold the same samples, whatever the
+// monotonic clock readings JSON drops
+func sameSamples(a, b []Sample) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Time.Equal(b[i].Time) || a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	small := HistoryConfig{Minute: 10, Hour: 2, Days: 1}
+	tests := []struct {
+		name          string
+		saved, loaded HistoryConfig
+		minute, hour  int
+	}{
+		{"same config", DefaultHistoryConfig(), DefaultHistoryConfig(), 60, 2},
+		{"truncated", DefaultHistoryConfig(), small, 10, 2},
+		{"padded", small, DefaultHistoryConfig(), 10, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saved := filledPiSugar(t, test.saved, 150)
+			var buf bytes.Buffer
+			if err := saved.SaveState(&buf); err != nil {
+				t.Fatal(err)
+			}
+			loaded, _ := newTestPiSugar(time.Second)
+			loaded.setHistoryConfig(test.loaded)
+			if err := loaded.LoadState(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if loaded.counter != saved.counter {
+				t.Errorf("counter = %d, want %d", loaded.counter, saved.counter)
+			}
+			minute := loaded.ChargeHistory(LastMinute)
+			if len(minute) != test.minute {
+				t.Fatalf("minute samples = %d, want %d", len(minute), test.minute)
+			}
+			// the most recent samples are kept
+			if want := saved.ChargeHistory(LastMinute); !sameSamples(minute, want[len(want)-test.minute:]) {
+				t.Errorf("minute samples = %v, want the last of %v", minute, want)
+			}
+			if got := len(loaded.VoltageHistory(LastHour)); got != test.hour {
+				t.Errorf("hour samples = %d, want %d", got, test.hour)
+			}
+			// padded windows still roll over at their own size
+			for i := 0; i < 100; i++ {
+				loaded.Refresh()
+			}
+			if got := len(loaded.ChargeHistory(LastMinute)); got != test.loaded.Minute {
+				t.Errorf("minute samples after refreshes = %d, want %d", got, test.loaded.Minute)
+			}
+		})
+	}
+}
+
+func TestLoadStateInvalid(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		state   savedState
+		err     error
+		counter int
+	}{
+		{"empty", savedState{Version: stateVersion, Counter: 5}, nil, 5},
+		{"counter reset", savedState{Version: stateVersion, Counter: 1, MinuteCharge: []Sample{{Time: at}, {Time: at.Add(time.Second)}}}, nil, 0},
+		{"out of order", savedState{Version: stateVersion, Counter: 5, HourVoltage: []Sample{{Time: at.Add(time.Minute)}, {Time: at}}}, ErrInvalidState, 7},
+		{"version", savedState{Version: stateVersion + 1}, nil, 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := json.Marshal(test.state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			piSugar, _ := newTestPiSugar(time.Second)
+			piSugar.counter = 7
+			err = piSugar.LoadState(bytes.NewReader(data))
+			switch {
+			case test.state.Version != stateVersion:
+				if err == nil {
+					t.Error("LoadState accepted another version")
+				}
+			case !errors.Is(err, test.err):
+				t.Errorf("LoadState error = %v, want %v", err, test.err)
+			}
+			if piSugar.counter != test.counter {
+				t.Errorf("counter = %d, want %d", piSugar.counter, test.counter)
+			}
+		})
+	}
+	piSugar, _ := newTestPiSugar(time.Second)
+	if err := piSugar.LoadState(strings.NewReader("{")); err == nil {
+		t.Error("LoadState accepted truncated JSON")
+	}
+}
@@ -0,0 +1,100 @@
+/*
+   status,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status is a consistent copy of the readings of the last Refresh()
+type Status struct {
+	Voltage      float64     `json:"voltage"`
+	InputVoltage float64     `json:"inputVoltage"`
+	Charge       int         `json:"charge"`
+	Temperature  int         `json:"temperature"`
+	Power        bool        `json:"power"`
+	PowerSource  PowerSource `json:"powerSource"`
+	Charging     bool        `json:"charging"`
+	ChargeState  ChargeState `json:"chargeState"`
+	Full         bool        `json:"full"`
+	Critical     bool        `json:"critical"`
+
+	// ChargingCurrent is in mA, nil on models without CurrentSense
+	ChargingCurrent *float64 `json:"chargingCurrent,omitempty"`
+
+	// TimeRemaining is EstimatedTimeRemaining(), 0 when unknown, in ns in JSON
+	TimeRemaining time.Duration `json:"timeRemaining,omitempty"`
+}
+
+// Snapshot returns all the readings at once, so they can't be torn by a
+// concurrent Refresh()
+func (piSugar *PiSugar) Snapshot() Status {
+	piSugar.mutex.RLock()
+	defer piSugar.mutex.RUnlock()
+	return piSugar.status()
+}
+
+// status builds the Status, the caller holding the mutex
+func (piSugar *PiSugar) status() Status {
+	status := Status{
+		Voltage:      piSugar.voltage,
+		InputVoltage: piSugar.inputVoltage,
+		Charge:       piSugar.charge,
+		Temperature:  piSugar.temperature,
+		Power:        piSugar.power,
+		PowerSource:  piSugar.powerSource,
+		Charging:     piSugar.charging,
+		ChargeState:  chargeState(piSugar.power, piSugar.charging, piSugar.full),
+		Full:         piSugar.full,
+		Critical:     piSugar.critical,
+
+		TimeRemaining: piSugar.timeRemaining(),
+	}
+	if piSugar.model.Capabilities().CurrentSense {
+		current := piSugar.current
+		status.ChargingCurrent = &current
+	}
+	return status
+}
+
+// String returns a one-line summary of the readings
+func (status Status) String() string {
+	return fmt.Sprintf("T = %d°C, V = %.3fV, B = %d%%, P = %t",
+		status.Temperature,
+		status.Voltage,
+		status.Charge,
+		status.Power)
+}
+
+// StatusLine returns String() followed by the charging state and, on
+// battery, the estimated time remaining once it is known
+func (status Status) StatusLine() string {
+	state := "on battery"
+	switch {
+	case status.Charging:
+		state = "charging"
+	case status.Power:
+		state = "powered"
+	case status.TimeRemaining > 0:
+		minutes := int(status.TimeRemaining.Round(time.Minute) / time.Minute)
+		state = fmt.Sprintf("on battery, %dh%02dm left", minutes/60, minutes%60)
+	}
+	return fmt.Sprintf("%v, %s", status, state)
+}
@@ -0,0 +1,67 @@
+/*
+   subscribe,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import "sync"
+
+// subscriberBufferSize is the number of snapshots a subscriber can lag
+// behind before the oldest are dropped
+const subscriberBufferSize = 4
+
+// Subscribe returns a channel receiving a Status after each Refresh(), and a
+// function to unsubscribe, which closes the channel. Each subscriber has its
+// own buffer: when a subscriber doesn't keep up, its oldest snapshots are
+// dropped, so Refresh() never blocks.
+func (piSugar *PiSugar) Subscribe() (<-chan Status, func()) {
+	ch := make(chan Status, subscriberBufferSize)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if piSugar.subscribers == nil {
+		piSugar.subscribers = make(map[chan Status]struct{})
+	}
+	piSugar.subscribers[ch] = struct{}{}
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			piSugar.mutex.Lock()
+			defer piSugar.mutex.Unlock()
+			delete(piSugar.subscribers, ch)
+			close(ch)
+		})
+	}
+}
+
+// publish sends status to all the subscribers, dropping their oldest
+// snapshot when their buffer is full
+func (piSugar *PiSugar) publish(status Status) {
+	for ch := range piSugar.subscribers {
+		for {
+			select {
+			case ch <- status:
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
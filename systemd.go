@@ -0,0 +1,66 @@
+/*
+   systemd,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"net"
+	"os"
+)
+
+// EnableSystemdNotify makes each healthy Refresh() send WATCHDOG=1 to
+// systemd, after READY=1 on the first one, so a service with WatchdogSec=
+// is restarted when the I2C bus hangs. It does nothing when not running
+// under systemd ($NOTIFY_SOCKET unset).
+func (piSugar *PiSugar) EnableSystemdNotify() {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	piSugar.systemdNotify = true
+}
+
+// notifySystemd reports a healthy refresh to systemd
+func (piSugar *PiSugar) notifySystemd() {
+	if !piSugar.systemdNotify {
+		return
+	}
+	state := "WATCHDOG=1"
+	if !piSugar.systemdReady {
+		state = "READY=1\n" + state
+	}
+	if err := sdNotify(state); err != nil {
+		Debug("Can't notify systemd %v", err)
+		return
+	}
+	piSugar.systemdReady = true
+}
+
+// sdNotify sends state to the systemd notification socket, like
+// sd_notify(3), without cgo
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
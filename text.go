@@ -0,0 +1,51 @@
+/*
+   text,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import "fmt"
+
+// The enum types implement fmt.Stringer, encoding.TextMarshaler and
+// encoding.TextUnmarshaler with these helpers, from a map of their names.
+
+// enumString returns the name of value, or the type and number if it has none
+func enumString[T ~int](value T, names map[T]string) string {
+	if name, ok := names[value]; ok {
+		return name
+	}
+	return fmt.Sprintf("%T(%d)", value, int(value))
+}
+
+// enumMarshal returns the name of value, failing if it has none
+func enumMarshal[T ~int](value T, names map[T]string) ([]byte, error) {
+	if name, ok := names[value]; ok {
+		return []byte(name), nil
+	}
+	return nil, fmt.Errorf("%w: %T %d", ErrOutOfRange, value, int(value))
+}
+
+// enumUnmarshal sets value to the one named text
+func enumUnmarshal[T ~int](text []byte, names map[T]string, value *T) error {
+	for v, name := range names {
+		if name == string(text) {
+			*value = v
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %T %q", ErrOutOfRange, *value, text)
+}
@@ -0,0 +1,131 @@
+/*
+   watchdog,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Watchdog registers, unconfirmed (see Capabilities)
+const (
+	control2Reg         = 0x03
+	watchdogEnableMask  = 0x80 // in control2Reg
+	watchdogFeedMask    = 0x20 // in control2Reg, cleared by the firmware
+	watchdogTimeoutReg  = 0x06 // in units of watchdogTimeoutUnit
+	watchdogTimeoutUnit = 2 * time.Second
+
+	// MinWatchdogTimeout and MaxWatchdogTimeout bound the firmware watchdog
+	MinWatchdogTimeout = watchdogTimeoutUnit
+	MaxWatchdogTimeout = 255 * watchdogTimeoutUnit
+
+	// DefaultKickInterval is the time between kicks of StartWatchdogKicker,
+	// short enough for MinWatchdogTimeout
+	DefaultKickInterval = time.Second
+)
+
+// EnableWatchdog makes the board power cycle the Pi if the watchdog isn't
+// fed within timeout, which is rounded up to 2s steps between
+// MinWatchdogTimeout and MaxWatchdogTimeout (510s).
+// Once enabled, each Refresh() feeds the watchdog, so it fires when the
+// polling stops.
+func (piSugar *PiSugar) EnableWatchdog(timeout time.Duration) error {
+	if timeout < MinWatchdogTimeout || timeout > MaxWatchdogTimeout {
+		return fmt.Errorf("%w: watchdog timeout %v", ErrOutOfRange, timeout)
+	}
+	units := byte((timeout + watchdogTimeoutUnit - 1) / watchdogTimeoutUnit)
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().Watchdog {
+		return ErrUnsupported
+	}
+	err := piSugar.writeEnabled(func() error {
+		if err := piSugar.writeRegister(watchdogTimeoutReg, units); err != nil {
+			return err
+		}
+		return piSugar.setRegisterBits(control2Reg, watchdogEnableMask|watchdogFeedMask, true)
+	})
+	if err != nil {
+		return err
+	}
+	piSugar.watchdog = true
+	return nil
+}
+
+// KickWatchdog resets the watchdog countdown. It returns ErrUnsupported on
+// boards without Watchdog.
+func (piSugar *PiSugar) KickWatchdog() error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().Watchdog {
+		return ErrUnsupported
+	}
+	return piSugar.setRegisterBits(control2Reg, watchdogFeedMask, true)
+}
+
+// FeedWatchdog resets the watchdog countdown
+//
+// Deprecated: use KickWatchdog
+func (piSugar *PiSugar) FeedWatchdog() error {
+	return piSugar.KickWatchdog()
+}
+
+// StartWatchdogKicker kicks the watchdog every interval (DefaultKickInterval
+// if not positive) in a goroutine, until ctx is done, so the board only
+// restarts the Pi once the process is gone. Use it when Refresh isn't
+// called regularly. The interval must be shorter than the watchdog timeout.
+func (piSugar *PiSugar) StartWatchdogKicker(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultKickInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			piSugar.mutex.Lock()
+			if piSugar.watchdog {
+				if err := piSugar.setRegisterBits(control2Reg, watchdogFeedMask, true); err != nil {
+					Debug("Can't kick watchdog %v", err)
+				}
+			}
+			piSugar.mutex.Unlock()
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// DisableWatchdog stops the watchdog. It returns ErrUnsupported on boards
+// without Watchdog.
+func (piSugar *PiSugar) DisableWatchdog() error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	if !piSugar.model.Capabilities().Watchdog {
+		return ErrUnsupported
+	}
+	if err := piSugar.setRegisterBits(control2Reg, watchdogEnableMask, false); err != nil {
+		return err
+	}
+	piSugar.watchdog = false
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+   writeprotect,
+   Copyright (C) 2024  Phil Hilger
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package pi_sugar
+
+// The PiSugar 3 firmware ignores writes to its registers unless
+// writeEnableReg holds writeEnableKey. Which registers are protected isn't
+// documented, so writeRegister unlocks them for every write to a PiSugar 3:
+// configuration, watchdog feeds, LED and tap or fault clears alike.
+const (
+	writeEnableReg = 0x0b
+	writeEnableKey = 0x29
+)
+
+// writeEnabled runs do with the registers unlocked, locking them again
+// afterwards, so a series of writes needs a single unlock. Calls can be
+// nested, only the outermost one toggles the lock. The caller holds the
+// mutex for the whole call, so no other write can relock the registers in
+// the middle of do.
+func (piSugar *PiSugar) writeEnabled(do func() error) error {
+	if !piSugar.model.isPiSugar3() {
+		return do()
+	}
+	if piSugar.writeEnableDepth == 0 {
+		if err := piSugar.writeRegisterRaw(writeEnableReg, writeEnableKey); err != nil {
+			return err
+		}
+	}
+	piSugar.writeEnableDepth++
+	err := do()
+	piSugar.writeEnableDepth--
+	if piSugar.writeEnableDepth == 0 {
+		if lockErr := piSugar.writeRegisterRaw(writeEnableReg, 0); err == nil {
+			err = lockErr
+		}
+	}
+	return err
+}
+
+// WithWriteEnabled runs fn with the registers unlocked once, for a series
+// of raw register writes made through write, as WriteRegister does them.
+// The PiSugar stays locked until fn returns, so fn must not call its
+// methods. Single writes don't need it: they unlock the registers on their
+// own.
+func (piSugar *PiSugar) WithWriteEnabled(fn func(write func(reg byte, data ...byte) error) error) error {
+	piSugar.mutex.Lock()
+	defer piSugar.mutex.Unlock()
+	return piSugar.writeEnabled(func() error {
+		return fn(func(reg byte, data ...byte) error {
+			return piSugar.writeRegisterChecked(reg, data)
+		})
+	})
+}